@@ -0,0 +1,154 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "debug/buildinfo"
+    "fmt"
+    "path/filepath"
+    "runtime"
+    "runtime/debug"
+    "strings"
+)
+
+// modulePath is the module path of this library.
+const modulePath = "github.com/matt-dunleavy/plugin-manager"
+
+// develVersion is the version the Go toolchain records for modules built
+// from a working tree; such versions cannot be compared.
+const develVersion = "(devel)"
+
+// IncompatibilityKind names what differs between a plugin build and the host.
+type IncompatibilityKind string
+
+const (
+    IncompatibleGoVersion  IncompatibilityKind = "go version"
+    IncompatiblePlatform   IncompatibilityKind = "platform"
+    IncompatibleBuildMode  IncompatibilityKind = "build mode"
+    IncompatibleLibrary    IncompatibilityKind = "plugin manager version"
+    IncompatibleDependency IncompatibilityKind = "dependency"
+)
+
+// Incompatibility is one difference between a plugin build and the host.
+// Module is set for library and dependency mismatches.
+type Incompatibility struct {
+    Kind   IncompatibilityKind
+    Module string
+    Plugin string
+    Host   string
+}
+
+func (i Incompatibility) String() string {
+    if i.Module != "" {
+        return fmt.Sprintf("%s %s: plugin has %s, host expects %s", i.Kind, i.Module, i.Plugin, i.Host)
+    }
+    return fmt.Sprintf("%s: plugin has %s, host expects %s", i.Kind, i.Plugin, i.Host)
+}
+
+// IncompatibilityError reports every difference found between a plugin
+// build and the host. It matches ErrIncompatibleBuild.
+type IncompatibilityError struct {
+    Plugin string
+    Issues []Incompatibility
+}
+
+func (e *IncompatibilityError) Error() string {
+    issues := make([]string, len(e.Issues))
+    for i, issue := range e.Issues {
+        issues[i] = issue.String()
+    }
+    return fmt.Sprintf("plugin %s was not built compatibly with the host: %s", e.Plugin, strings.Join(issues, "; "))
+}
+
+func (e *IncompatibilityError) Unwrap() error {
+    return ErrIncompatibleBuild
+}
+
+// CheckBuildCompatibility reads the build info embedded in a Go plugin and
+// compares it with the host binary: the Go toolchain, platform and build
+// mode, the version of this library and the versions of every module both
+// depend on. Go refuses to open plugins that differ in any of these, so
+// checking first turns a cryptic plugin.Open failure into a report of every
+// difference.
+func CheckBuildCompatibility(path string) error {
+    name := filepath.Base(path)
+
+    info, err := buildinfo.ReadFile(path)
+    if err != nil {
+        return &PluginError{Op: "buildinfo", Plugin: name, Err: err}
+    }
+
+    host, _ := debug.ReadBuildInfo()
+    if issues := compareBuilds(info, host); len(issues) > 0 {
+        return &IncompatibilityError{Plugin: name, Issues: issues}
+    }
+    return nil
+}
+
+// compareBuilds lists the differences between a plugin build and the host.
+// host may be nil when the host binary carries no build info.
+func compareBuilds(plugin, host *debug.BuildInfo) []Incompatibility {
+    var issues []Incompatibility
+
+    if plugin.GoVersion != runtime.Version() {
+        issues = append(issues, Incompatibility{Kind: IncompatibleGoVersion, Plugin: plugin.GoVersion, Host: runtime.Version()})
+    }
+
+    settings := make(map[string]string, len(plugin.Settings))
+    for _, setting := range plugin.Settings {
+        settings[setting.Key] = setting.Value
+    }
+    if platform := settings["GOOS"] + "/" + settings["GOARCH"]; platform != "/" && platform != runtime.GOOS+"/"+runtime.GOARCH {
+        issues = append(issues, Incompatibility{Kind: IncompatiblePlatform, Plugin: platform, Host: runtime.GOOS + "/" + runtime.GOARCH})
+    }
+    if mode := settings["-buildmode"]; mode != "" && mode != "plugin" {
+        issues = append(issues, Incompatibility{Kind: IncompatibleBuildMode, Plugin: mode, Host: "plugin"})
+    }
+
+    if host == nil {
+        return issues
+    }
+
+    hostModules := moduleVersions(host)
+    for _, dep := range plugin.Deps {
+        path, version := effectiveModule(dep)
+        hostVersion, ok := hostModules[path]
+        if !ok || hostVersion == version || hostVersion == develVersion || version == develVersion {
+            continue
+        }
+
+        kind := IncompatibleDependency
+        if path == modulePath {
+            kind = IncompatibleLibrary
+        }
+        issues = append(issues, Incompatibility{Kind: kind, Module: path, Plugin: version, Host: hostVersion})
+    }
+    return issues
+}
+
+// moduleVersions maps every module linked into a binary, including its main
+// module, to the version that was built.
+func moduleVersions(info *debug.BuildInfo) map[string]string {
+    versions := make(map[string]string, len(info.Deps)+1)
+    versions[info.Main.Path] = info.Main.Version
+    for _, dep := range info.Deps {
+        path, version := effectiveModule(dep)
+        versions[path] = version
+    }
+    return versions
+}
+
+// effectiveModule returns a dependency's path and version after replace
+// directives. Modules replaced by a local directory are treated as
+// development builds.
+func effectiveModule(dep *debug.Module) (string, string) {
+    if dep.Replace == nil {
+        return dep.Path, dep.Version
+    }
+    if dep.Replace.Version == "" {
+        return dep.Path, develVersion
+    }
+    return dep.Path, dep.Replace.Path + "@" + dep.Replace.Version
+}
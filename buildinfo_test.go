@@ -0,0 +1,175 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "reflect"
+    "runtime"
+    "runtime/debug"
+    "testing"
+)
+
+func TestCompareBuilds(t *testing.T) {
+    platform := runtime.GOOS + "/" + runtime.GOARCH
+    otherOS := "plan9"
+    if runtime.GOOS == otherOS {
+        otherOS = "linux"
+    }
+    goVersion := runtime.Version()
+
+    // pluginBuild returns the build info of a plugin built like the host,
+    // with deps.
+    pluginBuild := func(deps ...*debug.Module) *debug.BuildInfo {
+        return &debug.BuildInfo{
+            GoVersion: goVersion,
+            Main:      debug.Module{Path: "example.com/plugin"},
+            Deps:      deps,
+            Settings: []debug.BuildSetting{
+                {Key: "-buildmode", Value: "plugin"},
+                {Key: "GOARCH", Value: runtime.GOARCH},
+                {Key: "GOOS", Value: runtime.GOOS},
+            },
+        }
+    }
+    host := &debug.BuildInfo{
+        GoVersion: goVersion,
+        Main:      debug.Module{Path: "example.com/host", Version: develVersion},
+        Deps: []*debug.Module{
+            {Path: modulePath, Version: "v1.2.0"},
+            {Path: "go.uber.org/zap", Version: "v1.27.0"},
+            {Path: "example.com/forked", Version: "v1.0.0", Replace: &debug.Module{Path: "example.com/fork", Version: "v1.0.1"}},
+            {Path: "example.com/local", Version: "v1.0.0", Replace: &debug.Module{Path: "../local"}},
+        },
+    }
+
+    tests := []struct {
+        name   string
+        plugin *debug.BuildInfo
+        host   *debug.BuildInfo
+        want   []Incompatibility
+    }{
+        {
+            name: "match",
+            plugin: pluginBuild(
+                &debug.Module{Path: modulePath, Version: "v1.2.0"},
+                &debug.Module{Path: "go.uber.org/zap", Version: "v1.27.0"},
+                &debug.Module{Path: "example.com/forked", Version: "v1.0.0", Replace: &debug.Module{Path: "example.com/fork", Version: "v1.0.1"}},
+            ),
+            host: host,
+        },
+        {
+            name: "modules the host does not link are ignored",
+            plugin: pluginBuild(
+                &debug.Module{Path: "example.com/only-plugin", Version: "v0.1.0"},
+            ),
+            host: host,
+        },
+        {
+            name: "development builds are not compared",
+            plugin: pluginBuild(
+                &debug.Module{Path: "example.com/host", Version: "v3.0.0"},
+                &debug.Module{Path: "example.com/local", Version: "v1.0.0", Replace: &debug.Module{Path: "../elsewhere"}},
+                &debug.Module{Path: "go.uber.org/zap", Version: "v1.27.0", Replace: &debug.Module{Path: "../zap"}},
+            ),
+            host: host,
+        },
+        {
+            name: "go version",
+            plugin: func() *debug.BuildInfo {
+                info := pluginBuild()
+                info.GoVersion = "go1.0"
+                return info
+            }(),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleGoVersion, Plugin: "go1.0", Host: goVersion}},
+        },
+        {
+            name: "dependency version",
+            plugin: pluginBuild(
+                &debug.Module{Path: "go.uber.org/zap", Version: "v1.26.0"},
+            ),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleDependency, Module: "go.uber.org/zap", Plugin: "v1.26.0", Host: "v1.27.0"}},
+        },
+        {
+            name: "library version",
+            plugin: pluginBuild(
+                &debug.Module{Path: modulePath, Version: "v1.1.0"},
+            ),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleLibrary, Module: modulePath, Plugin: "v1.1.0", Host: "v1.2.0"}},
+        },
+        {
+            name: "replace only in the host",
+            plugin: pluginBuild(
+                &debug.Module{Path: "example.com/forked", Version: "v1.0.0"},
+            ),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleDependency, Module: "example.com/forked", Plugin: "v1.0.0", Host: "example.com/fork@v1.0.1"}},
+        },
+        {
+            name: "replace only in the plugin",
+            plugin: pluginBuild(
+                &debug.Module{Path: "go.uber.org/zap", Version: "v1.27.0", Replace: &debug.Module{Path: "example.com/zap", Version: "v1.27.0"}},
+            ),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleDependency, Module: "go.uber.org/zap", Plugin: "example.com/zap@v1.27.0", Host: "v1.27.0"}},
+        },
+        {
+            name: "replaced by another version",
+            plugin: pluginBuild(
+                &debug.Module{Path: "example.com/forked", Version: "v1.0.0", Replace: &debug.Module{Path: "example.com/fork", Version: "v1.0.2"}},
+            ),
+            host: host,
+            want: []Incompatibility{{Kind: IncompatibleDependency, Module: "example.com/forked", Plugin: "example.com/fork@v1.0.2", Host: "example.com/fork@v1.0.1"}},
+        },
+        {
+            name: "platform and build mode",
+            plugin: &debug.BuildInfo{
+                GoVersion: goVersion,
+                Settings: []debug.BuildSetting{
+                    {Key: "-buildmode", Value: "exe"},
+                    {Key: "GOARCH", Value: runtime.GOARCH},
+                    {Key: "GOOS", Value: otherOS},
+                },
+            },
+            host: host,
+            want: []Incompatibility{
+                {Kind: IncompatiblePlatform, Plugin: otherOS + "/" + runtime.GOARCH, Host: platform},
+                {Kind: IncompatibleBuildMode, Plugin: "exe", Host: "plugin"},
+            },
+        },
+        {
+            name: "every difference is reported",
+            plugin: func() *debug.BuildInfo {
+                info := pluginBuild(
+                    &debug.Module{Path: modulePath, Version: "v1.1.0"},
+                    &debug.Module{Path: "go.uber.org/zap", Version: "v1.26.0"},
+                )
+                info.GoVersion = "go1.0"
+                return info
+            }(),
+            host: host,
+            want: []Incompatibility{
+                {Kind: IncompatibleGoVersion, Plugin: "go1.0", Host: goVersion},
+                {Kind: IncompatibleLibrary, Module: modulePath, Plugin: "v1.1.0", Host: "v1.2.0"},
+                {Kind: IncompatibleDependency, Module: "go.uber.org/zap", Plugin: "v1.26.0", Host: "v1.27.0"},
+            },
+        },
+        {
+            name: "dependencies are not compared without host build info",
+            plugin: pluginBuild(
+                &debug.Module{Path: "go.uber.org/zap", Version: "v1.26.0"},
+            ),
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := compareBuilds(tt.plugin, tt.host); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("compareBuilds returned %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
@@ -11,9 +11,11 @@ import (
 )
 
 type Config struct {
-    Enabled map[string]bool `json:"enabled"`
-    path    string
-    mu      sync.RWMutex
+    Enabled  map[string]bool       `json:"enabled"`
+    Policies map[string]LoadPolicy `json:"policies,omitempty"`
+    Paths    map[string][]string   `json:"paths,omitempty"`
+    path     string
+    mu       sync.RWMutex
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -65,6 +67,16 @@ func (c *Config) DisablePlugin(name string) error {
     return nil
 }
 
+// IsDisabled reports whether a plugin is disabled in the config. Plugins the
+// config does not mention are not disabled.
+func (c *Config) IsDisabled(name string) bool {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    enabled, ok := c.Enabled[name]
+    return ok && !enabled
+}
+
 func (c *Config) EnabledPlugins() []string {
     c.mu.RLock()
     defer c.mu.RUnlock()
@@ -76,4 +88,45 @@ func (c *Config) EnabledPlugins() []string {
         }
     }
     return enabled
-}
\ No newline at end of file
+}
+
+// SetLoadPolicy records whether a plugin is loaded eagerly or lazily.
+func (c *Config) SetLoadPolicy(name string, policy LoadPolicy) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.Policies == nil {
+        c.Policies = make(map[string]LoadPolicy)
+    }
+    c.Policies[name] = policy
+    return nil
+}
+
+// LoadPolicy returns the load policy recorded for a plugin, if any.
+func (c *Config) LoadPolicy(name string) (LoadPolicy, bool) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    policy, ok := c.Policies[name]
+    return policy, ok
+}
+
+// SetPluginPaths records where the versions of a plugin were loaded from.
+func (c *Config) SetPluginPaths(name string, paths []string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.Paths == nil {
+        c.Paths = make(map[string][]string)
+    }
+    c.Paths[name] = paths
+    return nil
+}
+
+// PluginPaths returns the paths recorded for a plugin, if any.
+func (c *Config) PluginPaths(name string) []string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    return c.Paths[name]
+}
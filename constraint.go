@@ -0,0 +1,271 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Constraint is a set of versions a dependency accepts, such as
+// ">=1.2 <2.0", "^1.4", "~1.4.2", "1.x" or "1.0 || 2.0". It is a list of
+// groups separated by "||", of which a version must satisfy at least one. A
+// group is a list of space-separated comparators, all of which a version
+// must satisfy:
+//
+//   - "1.2.3" or "=1.2.3" accepts exactly 1.2.3, and "!=1.2.3" all others.
+//   - ">1.2.3", ">=1.2.3", "<1.2.3" and "<=1.2.3" compare by precedence.
+//   - "1.2", "1.2.x" and "1.2.*" accept any 1.2 version, "1" and "1.x" any
+//     1 version, and "*", "x" or an empty constraint any version. Partial
+//     versions after an operator are filled in with zeros, except that
+//     ">1.2" means ">=1.3.0" and "<=1.2" means "<1.3.0".
+//   - "^1.2.3" accepts versions that do not change the leftmost non-zero
+//     part: >=1.2.3 <2.0.0, and "^0.2.3" >=0.2.3 <0.3.0.
+//   - "~1.2.3" accepts patch updates, >=1.2.3 <1.3.0, and "~1" minor
+//     updates, >=1.0.0 <2.0.0.
+//
+// The operator may be separated from its version by a space. Prereleases
+// only satisfy a group that has a comparator with a prerelease of the same
+// major, minor and patch version, so ">=1.0.0" does not accept 2.0.0-rc.1
+// but ">=2.0.0-rc.0" does.
+type Constraint struct {
+    raw    string
+    groups [][]comparator
+}
+
+// comparator is a single comparison against a version. Every comparator a
+// constraint is written with is reduced to these.
+type comparator struct {
+    op      string
+    version Version
+}
+
+// constraintOperators lists the operators a comparator may start with,
+// longest first so that ">=" is not read as ">".
+var constraintOperators = []string{">=", "<=", "==", "!=", ">", "<", "=", "^", "~"}
+
+// ParseConstraint parses a version constraint. The returned error wraps
+// ErrInvalidConstraint.
+func ParseConstraint(s string) (*Constraint, error) {
+    c := &Constraint{raw: strings.TrimSpace(s)}
+    if c.raw == "" {
+        c.groups = [][]comparator{nil}
+        return c, nil
+    }
+
+    for _, group := range strings.Split(c.raw, "||") {
+        fields := strings.Fields(group)
+        if len(fields) == 0 {
+            return nil, fmt.Errorf("%w %q: empty alternative", ErrInvalidConstraint, s)
+        }
+
+        var comparators []comparator
+        for i := 0; i < len(fields); i++ {
+            op, version := splitOperator(fields[i])
+            if version == "" {
+                if i+1 == len(fields) {
+                    return nil, fmt.Errorf("%w %q: %s has no version", ErrInvalidConstraint, s, op)
+                }
+                i++
+                version = fields[i]
+            }
+
+            expanded, err := expandComparator(op, version)
+            if err != nil {
+                return nil, fmt.Errorf("%w %q: %w", ErrInvalidConstraint, s, err)
+            }
+            comparators = append(comparators, expanded...)
+        }
+        c.groups = append(c.groups, comparators)
+    }
+    return c, nil
+}
+
+// splitOperator splits a comparator into its operator, if any, and version.
+func splitOperator(field string) (string, string) {
+    for _, op := range constraintOperators {
+        if strings.HasPrefix(field, op) {
+            return op, field[len(op):]
+        }
+    }
+    return "", field
+}
+
+// partialVersion is a version in a constraint, in which trailing parts may
+// be left out or given as wildcards.
+type partialVersion struct {
+    version Version
+    // parts is how many of the major, minor and patch versions are given.
+    parts int
+}
+
+func parsePartialVersion(s string) (partialVersion, error) {
+    if strings.ContainsAny(s, "-+") {
+        v, err := ParseVersion(s)
+        return partialVersion{version: v, parts: 3}, err
+    }
+
+    fields := strings.Split(s, ".")
+    if len(fields) > 3 {
+        return partialVersion{}, fmt.Errorf("%w %q: too many parts", ErrInvalidVersion, s)
+    }
+
+    var p partialVersion
+    numbers := []*uint64{&p.version.Major, &p.version.Minor, &p.version.Patch}
+    for i, field := range fields {
+        if field == "x" || field == "X" || field == "*" {
+            for _, rest := range fields[i+1:] {
+                if rest != "x" && rest != "X" && rest != "*" {
+                    return partialVersion{}, fmt.Errorf("%w %q: %s follows a wildcard", ErrInvalidVersion, s, rest)
+                }
+            }
+            break
+        }
+        n, err := parseNumber(field)
+        if err != nil {
+            return partialVersion{}, fmt.Errorf("%w %q: %s", ErrInvalidVersion, s, err.Error())
+        }
+        *numbers[i] = n
+        p.parts++
+    }
+    return p, nil
+}
+
+// next returns the lowest version above every version p matches.
+func (p partialVersion) next() Version {
+    switch p.parts {
+    case 1:
+        return Version{Major: p.version.Major + 1}
+    case 2:
+        return Version{Major: p.version.Major, Minor: p.version.Minor + 1}
+    default:
+        return Version{Major: p.version.Major, Minor: p.version.Minor, Patch: p.version.Patch + 1}
+    }
+}
+
+// expandComparator reduces a comparator to plain comparisons. A comparator
+// that accepts every version reduces to none.
+func expandComparator(op, version string) ([]comparator, error) {
+    p, err := parsePartialVersion(version)
+    if err != nil {
+        return nil, err
+    }
+    lower := p.version
+
+    if p.parts == 0 {
+        switch op {
+        case "", "=", "==", ">=", "<=", "^", "~":
+            return nil, nil
+        default:
+            return nil, fmt.Errorf("%s cannot be used with a wildcard", op)
+        }
+    }
+
+    switch op {
+    case "", "=", "==":
+        if p.parts == 3 {
+            return []comparator{{"=", lower}}, nil
+        }
+        return []comparator{{">=", lower}, {"<", p.next()}}, nil
+    case "!=":
+        if p.parts != 3 {
+            return nil, fmt.Errorf("!= needs a full version, got %s", version)
+        }
+        return []comparator{{"!=", lower}}, nil
+    case ">":
+        if p.parts == 3 {
+            return []comparator{{">", lower}}, nil
+        }
+        return []comparator{{">=", p.next()}}, nil
+    case ">=":
+        return []comparator{{">=", lower}}, nil
+    case "<":
+        return []comparator{{"<", lower}}, nil
+    case "<=":
+        if p.parts == 3 {
+            return []comparator{{"<=", lower}}, nil
+        }
+        return []comparator{{"<", p.next()}}, nil
+    case "^":
+        var upper Version
+        switch {
+        case lower.Major > 0 || p.parts == 1:
+            upper = Version{Major: lower.Major + 1}
+        case lower.Minor > 0 || p.parts == 2:
+            upper = Version{Minor: lower.Minor + 1}
+        default:
+            upper = Version{Patch: lower.Patch + 1}
+        }
+        return []comparator{{">=", lower}, {"<", upper}}, nil
+    case "~":
+        upper := Version{Major: lower.Major, Minor: lower.Minor + 1}
+        if p.parts == 1 {
+            upper = Version{Major: lower.Major + 1}
+        }
+        return []comparator{{">=", lower}, {"<", upper}}, nil
+    }
+    return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// Check reports whether v satisfies the constraint.
+func (c *Constraint) Check(v Version) bool {
+    for _, group := range c.groups {
+        if checkGroup(group, v) {
+            return true
+        }
+    }
+    return false
+}
+
+func checkGroup(group []comparator, v Version) bool {
+    prereleaseAllowed := len(v.Prerelease) == 0
+    for _, cmp := range group {
+        if !cmp.check(v) {
+            return false
+        }
+        if len(cmp.version.Prerelease) > 0 && cmp.version.Major == v.Major && cmp.version.Minor == v.Minor && cmp.version.Patch == v.Patch {
+            prereleaseAllowed = true
+        }
+    }
+    return prereleaseAllowed
+}
+
+func (cmp comparator) check(v Version) bool {
+    c := v.Compare(cmp.version)
+    switch cmp.op {
+    case "=":
+        return c == 0
+    case "!=":
+        return c != 0
+    case ">":
+        return c > 0
+    case ">=":
+        return c >= 0
+    case "<":
+        return c < 0
+    default:
+        return c <= 0
+    }
+}
+
+// String returns the constraint as it was written.
+func (c *Constraint) String() string {
+    return c.raw
+}
+
+// isVersionCompatible reports whether version satisfies constraint. A
+// version that is not a valid semantic version satisfies no constraint; an
+// invalid constraint is returned as an error.
+func isVersionCompatible(version, constraint string) (bool, error) {
+    c, err := ParseConstraint(constraint)
+    if err != nil {
+        return false, err
+    }
+    v, err := ParseVersion(version)
+    if err != nil {
+        return false, nil
+    }
+    return c.Check(v), nil
+}
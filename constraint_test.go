@@ -0,0 +1,169 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestExpandComparator(t *testing.T) {
+    tests := []struct {
+        op, version string
+        want        string
+    }{
+        {"", "1.2.3", "=1.2.3"},
+        {"=", "1.2.3", "=1.2.3"},
+        {"==", "1.2", ">=1.2.0 <1.3.0"},
+        {"", "1", ">=1.0.0 <2.0.0"},
+        {"", "1.x", ">=1.0.0 <2.0.0"},
+        {"", "1.2.*", ">=1.2.0 <1.3.0"},
+        {"", "*", ""},
+        {"", "x", ""},
+        {"", "X.X", ""},
+        {">=", "*", ""},
+        {"^", "x", ""},
+        {"!=", "1.2.3", "!=1.2.3"},
+        {">", "1.2.3", ">1.2.3"},
+        {">", "1.2", ">=1.3.0"},
+        {">", "1", ">=2.0.0"},
+        {">=", "1.2", ">=1.2.0"},
+        {"<", "1.2", "<1.2.0"},
+        {"<=", "1.2.3", "<=1.2.3"},
+        {"<=", "1.2", "<1.3.0"},
+        {"<=", "1.x", "<2.0.0"},
+        {"^", "1.2.3", ">=1.2.3 <2.0.0"},
+        {"^", "1", ">=1.0.0 <2.0.0"},
+        {"^", "0.2.3", ">=0.2.3 <0.3.0"},
+        {"^", "0.2", ">=0.2.0 <0.3.0"},
+        {"^", "0.0.3", ">=0.0.3 <0.0.4"},
+        {"^", "0.0", ">=0.0.0 <0.1.0"},
+        {"^", "0.0.x", ">=0.0.0 <0.1.0"},
+        {"^", "0", ">=0.0.0 <1.0.0"},
+        {"^", "0.x", ">=0.0.0 <1.0.0"},
+        {"^", "1.2.3-beta.2", ">=1.2.3-beta.2 <2.0.0"},
+        {"~", "1.2.3", ">=1.2.3 <1.3.0"},
+        {"~", "1.2", ">=1.2.0 <1.3.0"},
+        {"~", "1", ">=1.0.0 <2.0.0"},
+        {"~", "0.2.3", ">=0.2.3 <0.3.0"},
+        {"~", "1.x", ">=1.0.0 <2.0.0"},
+    }
+    for _, tt := range tests {
+        comparators, err := expandComparator(tt.op, tt.version)
+        if err != nil {
+            t.Errorf("expandComparator(%q, %q): %v", tt.op, tt.version, err)
+            continue
+        }
+        got := make([]string, len(comparators))
+        for i, cmp := range comparators {
+            got[i] = cmp.op + cmp.version.String()
+        }
+        if strings.Join(got, " ") != tt.want {
+            t.Errorf("expandComparator(%q, %q) = %q, want %q", tt.op, tt.version, strings.Join(got, " "), tt.want)
+        }
+    }
+}
+
+func TestConstraintCheck(t *testing.T) {
+    tests := []struct {
+        constraint string
+        accepts    []string
+        rejects    []string
+    }{
+        {"", []string{"0.0.0", "1.2.3", "99.0.0"}, []string{"1.0.0-rc.1"}},
+        {"*", []string{"0.0.0", "1.2.3"}, []string{"1.0.0-rc.1"}},
+        {"x", []string{"0.1.0", "2.0.0"}, nil},
+        {"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4", "1.2.3-rc.1"}},
+        {"=1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+        {"!=1.2.3", []string{"1.2.2", "1.2.4"}, []string{"1.2.3"}},
+        {"1.x", []string{"1.0.0", "1.99.99"}, []string{"0.9.9", "2.0.0"}},
+        {"1.2.x", []string{"1.2.0", "1.2.99"}, []string{"1.1.9", "1.3.0"}},
+        {">1.2", []string{"1.3.0", "2.0.0"}, []string{"1.2.0", "1.2.9"}},
+        {">1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+        {">=1.2", []string{"1.2.0", "3.0.0"}, []string{"1.1.9"}},
+        {"<1.2", []string{"1.1.9"}, []string{"1.2.0"}},
+        {"<=1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+        {"<=1", []string{"1.9.9"}, []string{"2.0.0"}},
+        {">=1.2 <2.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+        {">= 1.2.0 < 2.0.0", []string{"1.5.0"}, []string{"2.0.0"}},
+        {"^1.4", []string{"1.4.0", "1.9.0"}, []string{"1.3.9", "2.0.0"}},
+        {"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.2.2", "0.3.0", "1.0.0"}},
+        {"^0.0.3", []string{"0.0.3"}, []string{"0.0.2", "0.0.4", "0.1.0"}},
+        {"^0.0.x", []string{"0.0.0", "0.0.9"}, []string{"0.1.0"}},
+        {"^0.x", []string{"0.0.1", "0.9.0"}, []string{"1.0.0"}},
+        {"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}},
+        {"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+        {"~0.2", []string{"0.2.0", "0.2.9"}, []string{"0.3.0"}},
+        {"1.0 || 2.0", []string{"1.0.5", "2.0.1"}, []string{"1.1.0", "3.0.0"}},
+        {"<1.0.0 || >=2.0.0 <3.0.0", []string{"0.5.0", "2.5.0"}, []string{"1.5.0", "3.0.0"}},
+        {"^1.0 || ^3.0", []string{"1.2.0", "3.1.0"}, []string{"2.0.0"}},
+
+        // Prereleases only match a group with a comparator that has a
+        // prerelease of the same major, minor and patch version.
+        {">=1.0.0", []string{"2.0.0"}, []string{"2.0.0-rc.1", "1.0.1-alpha"}},
+        {">=2.0.0-rc.0", []string{"2.0.0-rc.0", "2.0.0-rc.1", "2.0.0", "2.1.0"}, []string{"1.9.9", "2.0.1-rc.1", "2.1.0-rc.1"}},
+        {"^1.2.3-beta.2", []string{"1.2.3-beta.2", "1.2.3-beta.11", "1.2.3", "1.3.0"}, []string{"1.2.3-beta.1", "1.2.3-alpha", "1.2.4-alpha", "2.0.0"}},
+        {"1.0.0-alpha || 2.0.0-beta", []string{"1.0.0-alpha", "2.0.0-beta"}, []string{"1.0.0-beta", "2.0.0-alpha"}},
+        {">=1.0.0-alpha <1.0.0", []string{"1.0.0-alpha.1", "1.0.0-rc.1"}, []string{"1.0.0", "0.9.0-alpha"}},
+    }
+    for _, tt := range tests {
+        c, err := ParseConstraint(tt.constraint)
+        if err != nil {
+            t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+            continue
+        }
+        for _, s := range tt.accepts {
+            if v := mustParseVersion(t, s); !c.Check(v) {
+                t.Errorf("%q does not accept %s", tt.constraint, s)
+            }
+        }
+        for _, s := range tt.rejects {
+            if v := mustParseVersion(t, s); c.Check(v) {
+                t.Errorf("%q accepts %s", tt.constraint, s)
+            }
+        }
+    }
+}
+
+func TestParseConstraintRejectsMalformed(t *testing.T) {
+    tests := []string{
+        "||",
+        "1.0 ||",
+        "|| 1.0",
+        "1.0 || || 2.0",
+        ">=",
+        ">=1.0 <",
+        ">*",
+        "<x",
+        "!=*",
+        "!=1.2",
+        "1.x.2",
+        "1.2.3.4",
+        "01.2",
+        "1.02.x",
+        "abc",
+        "^v1.2",
+        "v1.2.3",
+        "?1.2",
+        ">=1.2.3-",
+        "1.2-rc.1",
+        ">=1.2.3-01",
+    }
+    for _, s := range tests {
+        if c, err := ParseConstraint(s); !errors.Is(err, ErrInvalidConstraint) {
+            t.Errorf("ParseConstraint(%q) = %v, %v, want ErrInvalidConstraint", s, c, err)
+        }
+    }
+}
+
+func mustParseVersion(t *testing.T, s string) Version {
+    t.Helper()
+    v, err := ParseVersion(s)
+    if err != nil {
+        t.Fatalf("ParseVersion(%q): %v", s, err)
+    }
+    return v
+}
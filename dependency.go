@@ -0,0 +1,470 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license 
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// loadResult records the outcome of loading one plugin of a batch.
+type loadResult struct {
+    name string
+    path string
+    err  error
+}
+
+// LoadPlugins loads a set of plugins in dependency order, so that every
+// plugin is initialized after the plugins listed in its Dependencies.
+// Independent plugins are loaded concurrently up to the limit set with
+// SetLoadConcurrency. A plugin that fails to load does not stop its
+// independent siblings; the returned error joins every failure. If the
+// plugins form a dependency cycle, nothing is loaded and
+// ErrCircularDependency is returned with the cycle path.
+func (m *Manager) LoadPlugins(paths []string) error {
+    return m.LoadPluginsContext(context.Background(), paths)
+}
+
+func (m *Manager) LoadPluginsContext(ctx context.Context, paths []string) error {
+    results, err := m.loadPlugins(ctx, paths)
+    if err != nil {
+        return err
+    }
+
+    var errs []error
+    for _, result := range results {
+        if result.err != nil {
+            errs = append(errs, result.err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// SetLoadConcurrency sets how many plugins LoadPlugins, LoadEnabledPlugins and
+// DiscoverPlugins open and initialize at the same time. Plugins are still
+// only initialized once all of their dependencies are. Values below one load
+// plugins one at a time, which is the default.
+func (m *Manager) SetLoadConcurrency(n int) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.loadConcurrency = n
+}
+
+func (m *Manager) loadPlugins(ctx context.Context, paths []string) ([]loadResult, error) {
+    var results []loadResult
+    var registered []*pluginEntry
+    requested := make(map[string]string)
+
+    // Manifests are read up front, so the batch can be planned before any
+    // plugin is opened.
+    manifests := make(map[string]*Manifest)
+    resolved := make([]string, 0, len(paths))
+    for _, path := range paths {
+        binary, manifest, err := resolvePlugin(path)
+        if err != nil {
+            results = append(results, loadResult{nameFromPath(path), path, fmt.Errorf("failed to read manifest: %w", err)})
+            continue
+        }
+        manifests[binary] = manifest
+        resolved = append(resolved, binary)
+    }
+
+    m.mu.Lock()
+    if m.closed {
+        m.mu.Unlock()
+        return nil, ErrManagerClosed
+    }
+    workers := max(m.loadConcurrency, 1)
+    for _, path := range resolved {
+        key := expectedKey(path, manifests[path])
+        if other, ok := requested[key]; ok {
+            err := fmt.Errorf("plugin %s requested more than once", key)
+            if other != path {
+                err = fmt.Errorf("%w: %s is claimed by both %s and %s", ErrDuplicatePluginName, key, other, path)
+            }
+            results = append(results, loadResult{key, path, err})
+            continue
+        }
+        requested[key] = path
+
+        entry, err := m.registerPlugin(key, path, manifests[path])
+        if err != nil {
+            results = append(results, loadResult{key, path, err})
+            continue
+        }
+        if entry.lazy {
+            // Lazy plugins are activated on first use.
+            entry.mu.Unlock()
+            results = append(results, loadResult{key, path, nil})
+            continue
+        }
+        registered = append(registered, entry)
+    }
+    m.mu.Unlock()
+
+    defer func() {
+        for _, entry := range registered {
+            entry.mu.Unlock()
+        }
+    }()
+
+    unplanned, err := m.planLoad(registered)
+    if err != nil {
+        for _, entry := range registered {
+            m.failPlugin(entry, err)
+        }
+        return nil, err
+    }
+
+    openErrs := make([]error, len(registered))
+    sem := make(chan struct{}, workers)
+    var wg sync.WaitGroup
+    for i, entry := range registered {
+        if err := unplanned[entry.key]; err != nil {
+            openErrs[i] = m.failPlugin(entry, fmt.Errorf("dependency check failed for %s: %w", entry.key, err))
+            continue
+        }
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            openErrs[i] = m.openPlugin(ctx, entry)
+        }()
+    }
+    wg.Wait()
+
+    opened := make(map[string]*pluginEntry)
+    graph := make(map[string][]string)
+
+    for i, entry := range registered {
+        if err := openErrs[i]; err != nil {
+            results = append(results, loadResult{entry.key, entry.path, err})
+            continue
+        }
+        opened[entry.key] = entry
+    }
+
+    // Plugins are known by the names and versions they report once opened,
+    // and dependencies may name them by an alias. A plugin waits for every
+    // version of a dependency in the batch, so that it is initialized
+    // against the best one.
+    m.mu.RLock()
+    for key, entry := range opened {
+        graph[key] = make([]string, 0, len(entry.metadata.Dependencies))
+        for dep := range entry.metadata.Dependencies {
+            for _, depEntry := range m.dependencyCandidatesLocked(dep) {
+                if opened[depEntry.key] == depEntry && depEntry != entry {
+                    graph[key] = append(graph[key], depEntry.key)
+                }
+            }
+        }
+    }
+    m.mu.RUnlock()
+
+    order, err := sortDependencies(graph)
+    if err != nil {
+        for _, entry := range opened {
+            m.failPlugin(entry, err)
+        }
+        return nil, err
+    }
+
+    // Each plugin waits for the plugins of the batch it depends on before it
+    // takes a worker slot, so independent plugins are initialized side by
+    // side and the acyclic graph guarantees progress.
+    done := make(map[string]chan struct{}, len(order))
+    for _, pluginName := range order {
+        done[pluginName] = make(chan struct{})
+    }
+
+    activateErrs := make([]error, len(order))
+    for i, pluginName := range order {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            defer close(done[pluginName])
+
+            for _, dep := range graph[pluginName] {
+                if ch, ok := done[dep]; ok {
+                    <-ch
+                }
+            }
+
+            sem <- struct{}{}
+            defer func() { <-sem }()
+            activateErrs[i] = m.activatePlugin(ctx, opened[pluginName])
+        }()
+    }
+    wg.Wait()
+
+    for i, pluginName := range order {
+        results = append(results, loadResult{pluginName, opened[pluginName].path, activateErrs[i]})
+    }
+
+    return results, nil
+}
+
+// planLoad checks the manifests of a batch of registered plugins before any
+// of them is opened. It returns why each plugin cannot be loaded: no version
+// of a dependency named in its manifest that is loaded, awaiting lazy
+// activation or part of the batch satisfies the constraint and can be loaded
+// itself. Plugins without a manifest are checked once they are opened, and
+// as their names are only known then, missing dependencies are not held
+// against a batch that contains such plugins, nor while lazy plugins without
+// a manifest await activation. A dependency cycle between manifests is
+// returned as an error.
+func (m *Manager) planLoad(entries []*pluginEntry) (map[string]error, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    batch := make(map[string]*pluginEntry, len(entries))
+    for _, entry := range entries {
+        batch[entry.key] = entry
+    }
+
+    // Lazy plugins awaiting activation are activated as dependencies, so
+    // they count as part of the batch.
+    pending := func(dep string) []*pluginEntry {
+        var planned []*pluginEntry
+        for _, depEntry := range m.dependencyCandidatesLocked(dep) {
+            state, _ := depEntry.currentState()
+            if batch[depEntry.key] == depEntry || depEntry.lazy && (state == StateDiscovered || state == StateLoaded) {
+                planned = append(planned, depEntry)
+            }
+        }
+        return planned
+    }
+
+    // Lazy plugins without a manifest are only known by their names once
+    // they are opened, which happens when a name cannot be resolved.
+    provisional := false
+    for _, entry := range m.plugins {
+        if state, _ := entry.currentState(); entry.lazy && entry.manifest == nil && state == StateDiscovered {
+            provisional = true
+            break
+        }
+    }
+
+    graph := make(map[string][]string)
+    for _, entry := range entries {
+        if entry.manifest == nil {
+            provisional = true
+            continue
+        }
+        graph[entry.key] = make([]string, 0, len(entry.manifest.Dependencies))
+        for _, dep := range entry.manifest.dependencyNames() {
+            for _, depEntry := range pending(dep) {
+                if depEntry != entry {
+                    graph[entry.key] = append(graph[entry.key], depEntry.key)
+                }
+            }
+        }
+    }
+
+    order, err := sortDependencies(graph)
+    if err != nil {
+        return nil, err
+    }
+
+    unplanned := make(map[string]error)
+    for _, key := range order {
+        manifest := batch[key].manifest
+        for _, dep := range manifest.dependencyNames() {
+            constraint := manifest.Dependencies[dep]
+            _, loadedErr := m.checkDependency(dep, constraint)
+            if loadedErr == nil {
+                continue
+            }
+
+            if errors.Is(loadedErr, ErrInvalidConstraint) {
+                unplanned[key] = loadedErr
+                break
+            }
+
+            err, planned := loadedErr, false
+            for _, depEntry := range pending(dep) {
+                if depEntry == batch[key] {
+                    continue
+                }
+                compatible := true
+                if depEntry.manifest != nil {
+                    compatible, _ = isVersionCompatible(depEntry.manifest.Version, constraint)
+                }
+                switch {
+                case unplanned[depEntry.key] != nil:
+                    err = fmt.Errorf("%w: %s: %w", ErrMissingDependency, dep, unplanned[depEntry.key])
+                case !compatible:
+                    err = fmt.Errorf("%w for dependency %s: required %s, manifest has %s", ErrIncompatibleVersion, dep, constraint, depEntry.manifest.Version)
+                default:
+                    planned = true
+                }
+            }
+            if !planned && (err != loadedErr || !provisional) {
+                unplanned[key] = err
+                break
+            }
+        }
+    }
+    return unplanned, nil
+}
+
+// sortDependencies orders the nodes of graph so that every node comes after
+// the nodes it depends on. Edges to nodes outside the graph are ignored, as
+// those dependencies are expected to be loaded already. The order is
+// deterministic for a given graph.
+func sortDependencies(graph map[string][]string) ([]string, error) {
+    const (
+        unvisited = iota
+        visiting
+        visited
+    )
+
+    names := make([]string, 0, len(graph))
+    for name := range graph {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    state := make(map[string]int, len(graph))
+    order := make([]string, 0, len(graph))
+    var stack []string
+
+    var visit func(name string) error
+    visit = func(name string) error {
+        switch state[name] {
+        case visited:
+            return nil
+        case visiting:
+            start := 0
+            for i, n := range stack {
+                if n == name {
+                    start = i
+                    break
+                }
+            }
+            cycle := append(append([]string{}, stack[start:]...), name)
+            return fmt.Errorf("%w: %s", ErrCircularDependency, strings.Join(cycle, " -> "))
+        }
+
+        state[name] = visiting
+        stack = append(stack, name)
+
+        deps := append([]string{}, graph[name]...)
+        sort.Strings(deps)
+        for _, dep := range deps {
+            if _, ok := graph[dep]; !ok {
+                continue
+            }
+            if err := visit(dep); err != nil {
+                return err
+            }
+        }
+
+        stack = stack[:len(stack)-1]
+        state[name] = visited
+        order = append(order, name)
+        return nil
+    }
+
+    for _, name := range names {
+        if err := visit(name); err != nil {
+            return nil, err
+        }
+    }
+    return order, nil
+}
+
+// sortedKeys returns the keys of a dependency map in sorted order.
+func sortedKeys(deps map[string]string) []string {
+    keys := make([]string, 0, len(deps))
+    for key := range deps {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Dependents returns the keys of the loaded plugins whose dependencies
+// resolved to the plugin version that name refers to.
+func (m *Manager) Dependents(name string) []string {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    entry, exists := m.lookupLocked(name)
+    if !exists {
+        return nil
+    }
+    return m.dependentsOf(entry.key)
+}
+
+// dependentsOf returns the direct dependents of the plugin registered under
+// name in sorted order. The caller must hold m.mu.
+func (m *Manager) dependentsOf(name string) []string {
+    var dependents []string
+    for plugin, deps := range m.dependencies {
+        for _, dep := range deps {
+            if dep == name {
+                dependents = append(dependents, plugin)
+                break
+            }
+        }
+    }
+    sort.Strings(dependents)
+    return dependents
+}
+
+// UnloadPluginCascade unloads a plugin together with every plugin that
+// depends on it, directly or transitively. Dependents are unloaded first, in
+// reverse dependency order.
+func (m *Manager) UnloadPluginCascade(name string) error {
+    return m.UnloadPluginCascadeContext(context.Background(), name)
+}
+
+func (m *Manager) UnloadPluginCascadeContext(ctx context.Context, name string) error {
+    m.mu.RLock()
+    entry, exists := m.lookupLocked(name)
+    if !exists {
+        m.mu.RUnlock()
+        return ErrPluginNotFound
+    }
+    name = entry.key
+
+    graph := map[string][]string{name: m.dependencies[name]}
+    queue := []string{name}
+    for len(queue) > 0 {
+        current := queue[0]
+        queue = queue[1:]
+        for _, dependent := range m.dependentsOf(current) {
+            if _, seen := graph[dependent]; !seen {
+                graph[dependent] = m.dependencies[dependent]
+                queue = append(queue, dependent)
+            }
+        }
+    }
+    m.mu.RUnlock()
+
+    order, err := sortDependencies(graph)
+    if err != nil {
+        return err
+    }
+
+    for i := len(order) - 1; i >= 0; i-- {
+        entry, err := m.lockEntry(order[i])
+        if err != nil {
+            continue
+        }
+        err = m.unloadEntry(ctx, entry, true)
+        entry.mu.Unlock()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
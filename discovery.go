@@ -5,19 +5,21 @@
 package pluginmanager
 
 import (
+    "context"
     "crypto"
 	"crypto/rsa"
     "crypto/sha256"
     "crypto/x509"
     "encoding/pem"
+    "errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/ssh"
 )
@@ -29,20 +31,40 @@ type PluginRepository struct {
 }
 
 func (m *Manager) DiscoverPlugins(dir string) error {
-    return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+    var paths []string
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
         if err != nil {
             return err
         }
-        if filepath.Ext(path) == ".so" {
-            pluginName := strings.TrimSuffix(filepath.Base(path), ".so")
-            if err := m.LoadPlugin(path); err != nil {
-                m.logger.Warn("Failed to load discovered plugin", zap.String("plugin", pluginName), zap.Error(err))
-            } else {
-                m.logger.Info("Discovered and loaded plugin", zap.String("plugin", pluginName))
+        if info.IsDir() {
+            if isBundle(path) {
+                paths = append(paths, path)
+                return filepath.SkipDir
             }
+            return nil
+        }
+        if _, err := m.loaderFor(path); err == nil {
+            paths = append(paths, path)
         }
         return nil
     })
+    if err != nil {
+        return err
+    }
+
+    results, err := m.loadPlugins(context.Background(), paths)
+    if err != nil {
+        return err
+    }
+
+    for _, result := range results {
+        if result.err != nil {
+            m.logger.Warn("Failed to load discovered plugin", zap.String("plugin", result.name), zap.Error(result.err))
+        } else {
+            m.logger.Info("Discovered and loaded plugin", zap.String("plugin", result.name))
+        }
+    }
+    return nil
 }
 
 func (m *Manager) SetupRemoteRepository(url, sshKeyPath string) (*PluginRepository, error) {
@@ -122,16 +144,42 @@ func (m *Manager) UpdatePlugin(repo *PluginRepository, pluginName string) error
     return nil
 }
 
+// Verifier decides whether a plugin file may be opened.
+type Verifier interface {
+    Verify(path string) error
+}
+
+// RSAVerifier checks the RSA PKCS #1 v1.5 signature stored next to a plugin
+// in path+".sig", or else in the plugin's manifest, against a PEM-encoded
+// public key. It is the default Verifier.
+type RSAVerifier struct {
+    PublicKeyPath string
+}
+
+func (v *RSAVerifier) Verify(path string) error {
+    return verifySignature(path, v.PublicKeyPath)
+}
+
 func (m *Manager) VerifyPluginSignature(pluginPath string, publicKeyPath string) error {
+    return verifySignature(pluginPath, publicKeyPath)
+}
+
+func verifySignature(pluginPath string, publicKeyPath string) error {
     // Read the plugin file
     pluginData, err := os.ReadFile(pluginPath)
     if err != nil {
         return fmt.Errorf("failed to read plugin file: %w", err)
     }
 
-    // Read the signature file
+    // Read the signature file, falling back to the signature in the
+    // plugin's manifest
     signaturePath := pluginPath + ".sig"
     signatureData, err := os.ReadFile(signaturePath)
+    if errors.Is(err, fs.ErrNotExist) {
+        if manifest, manifestErr := ReadManifest(pluginPath); manifestErr == nil && len(manifest.Signature) > 0 {
+            signatureData, err = manifest.Signature, nil
+        }
+    }
     if err != nil {
         return fmt.Errorf("failed to read signature file: %w", err)
     }
@@ -14,9 +14,25 @@ var (
     ErrInvalidPluginInterface = errors.New("invalid plugin interface")
     ErrPluginNotFound         = errors.New("plugin not found")
     ErrIncompatibleVersion    = errors.New("incompatible plugin version")
+    ErrInvalidVersion         = errors.New("invalid semantic version")
+    ErrInvalidConstraint      = errors.New("invalid version constraint")
+    ErrIncompatibleHostAPI    = errors.New("plugin requires an unsupported host API version")
     ErrMissingDependency      = errors.New("missing plugin dependency")
     ErrCircularDependency     = errors.New("circular plugin dependency detected")
+    ErrPluginHasDependents    = errors.New("plugin is required by other loaded plugins")
     ErrPluginSandboxViolation = errors.New("plugin attempted to violate sandbox")
+    ErrPluginTimeout          = errors.New("plugin operation timed out")
+    ErrManagerClosed          = errors.New("plugin manager is shut down")
+    ErrPluginPanicked         = errors.New("plugin panicked")
+    ErrPluginQuarantined      = errors.New("plugin quarantined after repeated panics")
+    ErrInvalidPluginState     = errors.New("invalid plugin state")
+    ErrInvokeNotSupported     = errors.New("plugin does not support invocation")
+    ErrOperationNotFound      = errors.New("plugin operation not found")
+    ErrNoLoader               = errors.New("no loader accepts plugin")
+    ErrPluginExited           = errors.New("plugin process exited")
+    ErrIncompatibleBuild      = errors.New("plugin build is incompatible with the host")
+    ErrManifestMismatch       = errors.New("plugin does not match its manifest")
+    ErrDuplicatePluginName    = errors.New("plugin name claimed more than once")
 )
 
 type PluginError struct {
@@ -34,4 +50,18 @@ func (e *PluginError) Error() string {
 
 func (e *PluginError) Unwrap() error {
     return e.Err
+}
+
+// PanicError records a panic recovered at a plugin boundary.
+type PanicError struct {
+    Value any
+    Stack []byte
+}
+
+func (e *PanicError) Error() string {
+    return fmt.Sprintf("plugin panicked: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+    return ErrPluginPanicked
 }
\ No newline at end of file
@@ -5,6 +5,7 @@
 package pluginmanager
 
 import (
+    "runtime/debug"
     "sync"
 )
 
@@ -14,14 +15,41 @@ type Event interface {
 
 type PluginLoadedEvent struct {
     PluginName string
+    Version    string
 }
 
 func (e PluginLoadedEvent) Name() string {
     return "PluginLoaded"
 }
 
+// PluginRegisteredEvent is published when the manager learns of a plugin,
+// before it is opened. Lazy plugins are activated later, on first use.
+type PluginRegisteredEvent struct {
+    PluginName string
+    Version    string
+    Path       string
+    Policy     LoadPolicy
+}
+
+func (e PluginRegisteredEvent) Name() string {
+    return "PluginRegistered"
+}
+
+// PluginActivatedEvent is published when a plugin has been opened and
+// initialized and accepts executions.
+type PluginActivatedEvent struct {
+    PluginName string
+    Version    string
+    Lazy       bool
+}
+
+func (e PluginActivatedEvent) Name() string {
+    return "PluginActivated"
+}
+
 type PluginUnloadedEvent struct {
     PluginName string
+    Version    string
 }
 
 func (e PluginUnloadedEvent) Name() string {
@@ -30,16 +58,56 @@ func (e PluginUnloadedEvent) Name() string {
 
 type PluginHotReloadedEvent struct {
     PluginName string
+    Version    string
 }
 
 func (e PluginHotReloadedEvent) Name() string {
     return "PluginHotReloaded"
 }
 
+type PluginHotReloadFailedEvent struct {
+    PluginName string
+    Err        error
+}
+
+func (e PluginHotReloadFailedEvent) Name() string {
+    return "PluginHotReloadFailed"
+}
+
+type PluginStateChangedEvent struct {
+    PluginName string
+    Version    string
+    Path       string
+    OldState   PluginState
+    NewState   PluginState
+    Cause      error
+}
+
+func (e PluginStateChangedEvent) Name() string {
+    return "PluginStateChanged"
+}
+
+type PluginPanickedEvent struct {
+    PluginName string
+    Version    string
+    Op         string
+    Value      any
+    Stack      []byte
+}
+
+func (e PluginPanickedEvent) Name() string {
+    return "PluginPanicked"
+}
+
 type EventHandler func(Event)
 
+// PanicHandler is called with the recovered value and stack trace when an
+// event handler panics.
+type PanicHandler func(event Event, recovered any, stack []byte)
+
 type EventBus struct {
     handlers map[string][]EventHandler
+    onPanic  PanicHandler
     mu       sync.RWMutex
 }
 
@@ -55,10 +123,34 @@ func (eb *EventBus) Subscribe(eventName string, handler EventHandler) {
     eb.handlers[eventName] = append(eb.handlers[eventName], handler)
 }
 
+func (eb *EventBus) SetPanicHandler(handler PanicHandler) {
+    eb.mu.Lock()
+    defer eb.mu.Unlock()
+    eb.onPanic = handler
+}
+
+// setDefaultPanicHandler installs handler unless the bus already has one.
+func (eb *EventBus) setDefaultPanicHandler(handler PanicHandler) {
+    eb.mu.Lock()
+    defer eb.mu.Unlock()
+    if eb.onPanic == nil {
+        eb.onPanic = handler
+    }
+}
+
 func (eb *EventBus) Publish(event Event) {
     eb.mu.RLock()
     defer eb.mu.RUnlock()
     for _, handler := range eb.handlers[event.Name()] {
-        go handler(event)
+        go eb.dispatch(handler, event, eb.onPanic)
     }
+}
+
+func (eb *EventBus) dispatch(handler EventHandler, event Event, onPanic PanicHandler) {
+    defer func() {
+        if r := recover(); r != nil && onPanic != nil {
+            onPanic(event, r, debug.Stack())
+        }
+    }()
+    handler(event)
 }
\ No newline at end of file
@@ -41,6 +41,29 @@ func main() {
         }
     }
 
+    // Invoke a plugin with structured input
+    resp, err := manager.Invoke("MathPlugin", map[string]int{"a": 40, "b": 2})
+    if err != nil {
+        log.Printf("Failed to invoke plugin MathPlugin: %v", err)
+    } else {
+        var sum int
+        if err := resp.Decode(&sum); err == nil {
+            fmt.Println("MathPlugin: 40 + 2 =", sum)
+        }
+    }
+
+    // List and call named operations
+    for _, op := range manager.ListOperations() {
+        fmt.Printf("Operation %s: %s\n", op.QualifiedName(), op.Description)
+    }
+
+    resp, err = manager.CallOperation("MathPlugin.add", map[string]int{"a": 2, "b": 2})
+    if err != nil {
+        log.Printf("Failed to call MathPlugin.add: %v", err)
+    } else {
+        fmt.Println("MathPlugin.add returned", string(resp.Output))
+    }
+
     // Get and print plugin stats
     for _, name := range loadedPlugins {
         stats, err := manager.GetPluginStats(name)
@@ -53,7 +76,7 @@ func main() {
     }
 
     // Unload a plugin
-    err = manager.UnloadPlugin("hello.so")
+    err = manager.UnloadPlugin("HelloPlugin")
     if err != nil {
         log.Printf("Failed to unload plugin: %v", err)
     }
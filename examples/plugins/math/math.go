@@ -1,6 +1,7 @@
 package main
 
 import (
+    "context"
     "fmt"
 
     pm "github.com/matt-dunleavy/plugin-manager"
@@ -8,11 +9,24 @@ import (
 
 type MathPlugin struct{}
 
+type addInput struct {
+    A int `json:"a"`
+    B int `json:"b"`
+}
+
 func (p *MathPlugin) Metadata() pm.PluginMetadata {
     return pm.PluginMetadata{
         Name:    "MathPlugin",
         Version: "1.0.0",
         Dependencies: map[string]string{},
+        Operations: []pm.Operation{
+            {
+                Name:        "add",
+                Description: "Adds two integers",
+                Input:       `{"a": int, "b": int}`,
+                Output:      "int",
+            },
+        },
     }
 }
 
@@ -47,6 +61,19 @@ func (p *MathPlugin) PreUnload() error {
     return nil
 }
 
+func (p *MathPlugin) Invoke(ctx context.Context, req pm.InvokeRequest) (pm.InvokeResponse, error) {
+    switch req.Operation {
+    case "", "add":
+        var in addInput
+        if err := req.Decode(&in); err != nil {
+            return pm.InvokeResponse{}, err
+        }
+        return pm.NewInvokeResponse(p.Add(in.A, in.B))
+    default:
+        return pm.InvokeResponse{}, fmt.Errorf("unknown operation %q", req.Operation)
+    }
+}
+
 func (p *MathPlugin) Add(a, b int) int {
     return a + b
 }
@@ -0,0 +1,548 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// ExecProtocolVersion is the version of the JSON-over-stdio protocol spoken
+// with executable plugins. See docs/exec-plugins.md.
+const ExecProtocolVersion = 1
+
+// execProtocolEnv is set in the environment of every executable plugin to
+// the protocol version the manager speaks.
+const execProtocolEnv = "PLUGIN_MANAGER_EXEC_PROTOCOL"
+
+const (
+    defaultExecTimeout = 30 * time.Second
+    execMinBackoff     = 100 * time.Millisecond
+    execMaxBackoff     = 30 * time.Second
+    execWaitDelay      = time.Second
+    execCloseTimeout   = 5 * time.Second
+)
+
+// ExitError reports that an executable plugin exited with a non-zero code.
+type ExitError struct {
+    Code int
+}
+
+func (e *ExitError) Error() string {
+    return fmt.Sprintf("exited with code %d", e.Code)
+}
+
+// execRequest is one line the manager writes to an executable plugin.
+type execRequest struct {
+    Protocol  int             `json:"protocol"`
+    ID        uint64          `json:"id"`
+    Phase     string          `json:"phase"`
+    Operation string          `json:"operation,omitempty"`
+    Input     json.RawMessage `json:"input,omitempty"`
+}
+
+// execResponse is one line an executable plugin writes back.
+type execResponse struct {
+    ID          uint64          `json:"id"`
+    Output      json.RawMessage `json:"output,omitempty"`
+    Error       string          `json:"error,omitempty"`
+    Unsupported bool            `json:"unsupported,omitempty"`
+}
+
+// execMetadata is the response to the metadata phase.
+type execMetadata struct {
+    Name         string            `json:"name"`
+    Version      string            `json:"version"`
+    Dependencies map[string]string `json:"dependencies,omitempty"`
+    Operations   []Operation       `json:"operations,omitempty"`
+    HostAPI      string            `json:"host_api,omitempty"`
+    LongLived    bool              `json:"long_lived,omitempty"`
+}
+
+// ExecPluginExt is the file extension that marks executable plugins. Other
+// executable files are only loaded as plugins if their manifest sets exec.
+const ExecPluginExt = ".exec"
+
+// ExecLoader runs executable plugins written in any language. It matches
+// executable files that are marked as plugins, so that other programs in a
+// plugin directory are never run. Each call starts the
+// executable, writes one request line to its stdin and reads one response
+// line from its stdout; plugins whose metadata sets long_lived instead run
+// as a single supervised process that is restarted with backoff when it
+// exits. Lines the plugin writes to stderr are logged.
+type ExecLoader struct {
+    // Timeout bounds every call into the plugin. Zero means no limit beyond
+    // the manager's own timeouts.
+    Timeout time.Duration
+    // Logger receives the plugins' stderr.
+    Logger *zap.Logger
+}
+
+func (l *ExecLoader) Match(path string) bool {
+    info, err := os.Stat(path)
+    if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+        return false
+    }
+    if filepath.Ext(path) == ExecPluginExt {
+        return true
+    }
+    manifest, err := ReadManifest(path)
+    return err == nil && manifest.Exec
+}
+
+func (l *ExecLoader) Load(path string) (Plugin, error) {
+    p := &execPlugin{path: path, name: filepath.Base(path), loader: l}
+
+    resp, err := p.runOnce(context.Background(), execRequest{Phase: "metadata"})
+    if err != nil {
+        return nil, err
+    }
+
+    var metadata execMetadata
+    if err := json.Unmarshal(resp.Output, &metadata); err != nil {
+        return nil, &PluginError{Op: "metadata", Plugin: p.name, Err: fmt.Errorf("invalid metadata: %w", err)}
+    }
+    p.metadata = PluginMetadata{
+        Name:         metadata.Name,
+        Version:      metadata.Version,
+        Dependencies: metadata.Dependencies,
+        Operations:   metadata.Operations,
+        HostAPI:      metadata.HostAPI,
+    }
+
+    if metadata.LongLived {
+        p.done = make(chan struct{})
+        if err := p.start(); err != nil {
+            return nil, err
+        }
+        go p.supervise()
+    }
+    return p, nil
+}
+
+// execPlugin is the manager side of an executable plugin.
+type execPlugin struct {
+    path     string
+    name     string
+    loader   *ExecLoader
+    metadata PluginMetadata
+
+    // Long-lived plugins only.
+    mu          sync.Mutex
+    proc        *execProcess
+    nextID      uint64
+    initialized bool
+    closed      bool
+    done        chan struct{}
+}
+
+// execProcess is one run of a long-lived executable plugin.
+type execProcess struct {
+    cmd     *exec.Cmd
+    stdin   io.WriteCloser
+    stderr  *stderrLogger
+    started time.Time
+    exited  chan struct{}
+
+    mu      sync.Mutex
+    pending map[uint64]chan execResponse
+    err     error
+}
+
+// command prepares a run of the plugin executable, together with the logger
+// of its stderr, which must be flushed once the run has been waited for.
+func (p *execPlugin) command(ctx context.Context) (*exec.Cmd, *stderrLogger) {
+    stderr := &stderrLogger{logger: p.loader.logger(), plugin: p.name}
+    cmd := exec.CommandContext(ctx, p.path)
+    cmd.Env = append(os.Environ(), execProtocolEnv+"="+strconv.Itoa(ExecProtocolVersion))
+    cmd.Stderr = stderr
+    cmd.WaitDelay = execWaitDelay
+    return cmd, stderr
+}
+
+func (l *ExecLoader) logger() *zap.Logger {
+    if l.Logger == nil {
+        return zap.NewNop()
+    }
+    return l.Logger
+}
+
+// runOnce starts the executable for a single request and waits for it to
+// exit.
+func (p *execPlugin) runOnce(ctx context.Context, req execRequest) (execResponse, error) {
+    if p.loader.Timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, p.loader.Timeout)
+        defer cancel()
+    }
+
+    req.Protocol = ExecProtocolVersion
+    line, err := json.Marshal(req)
+    if err != nil {
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: err}
+    }
+
+    var stdout bytes.Buffer
+    cmd, stderr := p.command(ctx)
+    cmd.Stdin = bytes.NewReader(append(line, '\n'))
+    cmd.Stdout = &stdout
+
+    err = cmd.Run()
+    stderr.flush()
+    if err != nil {
+        if ctx.Err() != nil {
+            return execResponse{}, ctx.Err()
+        }
+        var exitErr *exec.ExitError
+        if errors.As(err, &exitErr) {
+            err = &ExitError{Code: exitErr.ExitCode()}
+        }
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: err}
+    }
+
+    var resp execResponse
+    if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+        if i := bytes.IndexByte(out, '\n'); i >= 0 {
+            out = out[:i]
+        }
+        if err := json.Unmarshal(out, &resp); err != nil {
+            return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: fmt.Errorf("invalid response: %w", err)}
+        }
+    }
+    return resp, nil
+}
+
+// start launches a long-lived plugin process. The caller must hold p.mu or
+// have exclusive access to p.
+func (p *execPlugin) start() error {
+    cmd, stderr := p.command(context.Background())
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+    if err := cmd.Start(); err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+
+    proc := &execProcess{
+        cmd:     cmd,
+        stdin:   stdin,
+        stderr:  stderr,
+        started: time.Now(),
+        exited:  make(chan struct{}),
+        pending: make(map[uint64]chan execResponse),
+    }
+    go proc.read(stdout)
+    p.proc = proc
+    return nil
+}
+
+// read delivers responses to their callers until the process exits.
+func (proc *execProcess) read(stdout io.Reader) {
+    scanner := bufio.NewScanner(stdout)
+    scanner.Buffer(nil, 16<<20)
+    for scanner.Scan() {
+        var resp execResponse
+        if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+            continue
+        }
+        proc.mu.Lock()
+        if ch, ok := proc.pending[resp.ID]; ok {
+            delete(proc.pending, resp.ID)
+            ch <- resp
+        }
+        proc.mu.Unlock()
+    }
+
+    exitErr := ErrPluginExited
+    var cmdErr *exec.ExitError
+    err := proc.cmd.Wait()
+    proc.stderr.flush()
+    if errors.As(err, &cmdErr) {
+        exitErr = fmt.Errorf("%w: %w", ErrPluginExited, &ExitError{Code: cmdErr.ExitCode()})
+    } else if err != nil {
+        exitErr = fmt.Errorf("%w: %v", ErrPluginExited, err)
+    }
+
+    proc.mu.Lock()
+    proc.err = exitErr
+    proc.pending = nil
+    proc.mu.Unlock()
+    close(proc.exited)
+}
+
+// supervise restarts a long-lived plugin process whenever it exits, waiting
+// longer after each quick crash, and replays the load hooks the plugin had
+// completed.
+func (p *execPlugin) supervise() {
+    logger := p.loader.logger()
+    backoff := execMinBackoff
+
+    for {
+        p.mu.Lock()
+        proc := p.proc
+        p.mu.Unlock()
+
+        select {
+        case <-proc.exited:
+        case <-p.done:
+            return
+        }
+
+        if time.Since(proc.started) > execMaxBackoff {
+            backoff = execMinBackoff
+        }
+        logger.Warn("Plugin process exited, restarting",
+            zap.String("plugin", p.name), zap.Error(proc.err), zap.Duration("backoff", backoff))
+
+        select {
+        case <-time.After(backoff):
+        case <-p.done:
+            return
+        }
+        backoff = min(backoff*2, execMaxBackoff)
+
+        p.mu.Lock()
+        if p.closed {
+            p.mu.Unlock()
+            return
+        }
+        err := p.start()
+        initialized := p.initialized
+        p.mu.Unlock()
+
+        if err != nil {
+            logger.Warn("Failed to restart plugin process", zap.String("plugin", p.name), zap.Error(err))
+            continue
+        }
+        if initialized {
+            for _, phase := range []string{"preload", "init", "postload"} {
+                if _, err := p.call(context.Background(), execRequest{Phase: phase}); err != nil {
+                    logger.Warn("Failed to replay plugin hook after restart", zap.String("plugin", p.name), zap.String("phase", phase), zap.Error(err))
+                    break
+                }
+            }
+        }
+    }
+}
+
+// call sends one request to the plugin and returns its response. Plugin
+// reported errors are returned as PluginErrors.
+func (p *execPlugin) call(ctx context.Context, req execRequest) (execResponse, error) {
+    var resp execResponse
+    var err error
+    if p.done == nil {
+        resp, err = p.runOnce(ctx, req)
+    } else {
+        resp, err = p.send(ctx, req)
+    }
+    if err != nil {
+        return resp, err
+    }
+    if resp.Error != "" {
+        return resp, &PluginError{Op: req.Phase, Plugin: p.name, Err: errors.New(resp.Error)}
+    }
+    return resp, nil
+}
+
+// send writes a request to the long-lived plugin process and waits for the
+// response with the same id. A call that times out kills the process, as
+// its state is unknown; the supervisor then restarts it.
+func (p *execPlugin) send(ctx context.Context, req execRequest) (execResponse, error) {
+    if p.loader.Timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, p.loader.Timeout)
+        defer cancel()
+    }
+
+    p.mu.Lock()
+    if p.closed {
+        p.mu.Unlock()
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: ErrPluginExited}
+    }
+    p.nextID++
+    req.ID = p.nextID
+    req.Protocol = ExecProtocolVersion
+    proc := p.proc
+    p.mu.Unlock()
+
+    ch := make(chan execResponse, 1)
+    proc.mu.Lock()
+    if proc.pending == nil {
+        err := proc.err
+        proc.mu.Unlock()
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: err}
+    }
+    proc.pending[req.ID] = ch
+    proc.mu.Unlock()
+
+    line, err := json.Marshal(req)
+    if err == nil {
+        _, err = proc.stdin.Write(append(line, '\n'))
+    }
+    if err != nil {
+        proc.cmd.Process.Kill()
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: fmt.Errorf("%w: %v", ErrPluginExited, err)}
+    }
+
+    select {
+    case resp := <-ch:
+        return resp, nil
+    case <-proc.exited:
+        return execResponse{}, &PluginError{Op: req.Phase, Plugin: p.name, Err: proc.err}
+    case <-ctx.Done():
+        proc.cmd.Process.Kill()
+        return execResponse{}, ctx.Err()
+    }
+}
+
+// hook runs a lifecycle phase. Lifecycle phases the plugin reports as
+// unsupported succeed.
+func (p *execPlugin) hook(ctx context.Context, phase string) error {
+    _, err := p.call(ctx, execRequest{Phase: phase})
+    return err
+}
+
+func (p *execPlugin) Metadata() PluginMetadata {
+    return p.metadata
+}
+
+func (p *execPlugin) PreLoad() error {
+    return p.hook(context.Background(), "preload")
+}
+
+func (p *execPlugin) Init() error {
+    return p.InitContext(context.Background())
+}
+
+func (p *execPlugin) InitContext(ctx context.Context) error {
+    return p.hook(ctx, "init")
+}
+
+func (p *execPlugin) PostLoad() error {
+    if err := p.hook(context.Background(), "postload"); err != nil {
+        return err
+    }
+
+    p.mu.Lock()
+    p.initialized = true
+    p.mu.Unlock()
+    return nil
+}
+
+func (p *execPlugin) Execute() error {
+    return p.ExecuteContext(context.Background())
+}
+
+// ExecuteContext runs the execute phase, which fails if the plugin reports
+// it as unsupported.
+func (p *execPlugin) ExecuteContext(ctx context.Context) error {
+    resp, err := p.call(ctx, execRequest{Phase: "execute"})
+    if err == nil && resp.Unsupported {
+        err = &PluginError{Op: "execute", Plugin: p.name, Err: errors.New("execute is not supported")}
+    }
+    return err
+}
+
+func (p *execPlugin) Invoke(ctx context.Context, req InvokeRequest) (InvokeResponse, error) {
+    resp, err := p.call(ctx, execRequest{Phase: "invoke", Operation: req.Operation, Input: req.Input})
+    if err != nil {
+        return InvokeResponse{}, err
+    }
+    if resp.Unsupported {
+        return InvokeResponse{}, ErrInvokeNotSupported
+    }
+    return InvokeResponse{Output: resp.Output}, nil
+}
+
+func (p *execPlugin) PreUnload() error {
+    return p.hook(context.Background(), "preunload")
+}
+
+func (p *execPlugin) Shutdown() error {
+    return p.ShutdownContext(context.Background())
+}
+
+func (p *execPlugin) ShutdownContext(ctx context.Context) error {
+    p.mu.Lock()
+    p.initialized = false
+    p.mu.Unlock()
+
+    return p.hook(ctx, "shutdown")
+}
+
+// Close stops the supervisor and the process of a long-lived plugin. The
+// process is asked to exit by closing its stdin and is killed if it has not
+// exited within execCloseTimeout.
+func (p *execPlugin) Close() error {
+    if p.done == nil {
+        return nil
+    }
+
+    p.mu.Lock()
+    if p.closed {
+        p.mu.Unlock()
+        return nil
+    }
+    p.closed = true
+    close(p.done)
+    proc := p.proc
+    p.mu.Unlock()
+
+    proc.stdin.Close()
+    select {
+    case <-proc.exited:
+    case <-time.After(execCloseTimeout):
+        proc.cmd.Process.Kill()
+        <-proc.exited
+    }
+    return nil
+}
+
+// stderrLogger logs each line written to it. Output that does not end in a
+// newline is logged by flush.
+type stderrLogger struct {
+    logger *zap.Logger
+    plugin string
+    buf    []byte
+}
+
+func (w *stderrLogger) Write(b []byte) (int, error) {
+    w.buf = append(w.buf, b...)
+    for {
+        i := bytes.IndexByte(w.buf, '\n')
+        if i < 0 {
+            break
+        }
+        w.logger.Info("Plugin stderr", zap.String("plugin", w.plugin), zap.ByteString("line", w.buf[:i]))
+        w.buf = w.buf[i+1:]
+    }
+    return len(b), nil
+}
+
+// flush logs the last line written if it did not end in a newline.
+func (w *stderrLogger) flush() {
+    if len(w.buf) > 0 {
+        w.logger.Info("Plugin stderr", zap.String("plugin", w.plugin), zap.ByteString("line", w.buf))
+        w.buf = nil
+    }
+}
@@ -0,0 +1,305 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+    "go.uber.org/zap/zaptest/observer"
+)
+
+// writeExecScript writes a shell script plugin called name to a temporary
+// directory and returns its path.
+func writeExecScript(t *testing.T, name, script string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), name)
+    if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// loadExecScript loads a shell script plugin with an ExecLoader logging to
+// the returned observer.
+func loadExecScript(t *testing.T, timeout time.Duration, name, script string) (*execPlugin, *observer.ObservedLogs) {
+    t.Helper()
+    core, logs := observer.New(zap.InfoLevel)
+    loader := &ExecLoader{Timeout: timeout, Logger: zap.New(core)}
+    plugin, err := loader.Load(writeExecScript(t, name, script))
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    p := plugin.(*execPlugin)
+    t.Cleanup(func() { p.Close() })
+    return p, logs
+}
+
+// waitForLines waits until the file at path has n lines and returns them.
+func waitForLines(t *testing.T, path string, n int) []string {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        data, _ := os.ReadFile(path)
+        lines := strings.Fields(string(data))
+        if len(lines) >= n {
+            return lines
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("%s has lines %v, want %d", filepath.Base(path), lines, n)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+func TestExecLoaderMatchesMarkedExecutables(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name string, mode os.FileMode) string {
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+            t.Fatal(err)
+        }
+        return path
+    }
+
+    marked := write("hello.exec", 0755)
+    helper := write("helper.sh", 0755)
+    notExecutable := write("data.exec", 0644)
+    declared := write("greeter.py", 0755)
+    if err := os.WriteFile(declared+".json", []byte(`{"name": "greeter", "version": "1.0.0", "exec": true}`), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    loader := &ExecLoader{}
+    for path, want := range map[string]bool{marked: true, helper: false, notExecutable: false, declared: true} {
+        if got := loader.Match(path); got != want {
+            t.Errorf("Match(%s) = %v, want %v", filepath.Base(path), got, want)
+        }
+    }
+}
+
+func TestExecPluginHandshake(t *testing.T) {
+    p, _ := loadExecScript(t, 0, "hello.exec", `
+while read -r req; do
+  case "$req" in
+    *'"protocol":1,'*'"phase":"metadata"'*)
+      echo "{\"output\":{\"name\":\"hello\",\"version\":\"1.0.$PLUGIN_MANAGER_EXEC_PROTOCOL\",\"dependencies\":{\"db\":\">= 1.0.0\"},\"operations\":[{\"name\":\"greet\"}]}}" ;;
+    *'"phase":"invoke"'*'"operation":"greet"'*) echo '{"output":{"greeting":"hi"}}' ;;
+    *) echo '{"unsupported":true}' ;;
+  esac
+done
+`)
+
+    want := PluginMetadata{
+        Name:         "hello",
+        Version:      "1.0.1",
+        Dependencies: map[string]string{"db": ">= 1.0.0"},
+        Operations:   []Operation{{Name: "greet"}},
+    }
+    if got := p.Metadata(); !reflect.DeepEqual(got, want) {
+        t.Fatalf("Metadata() = %+v, want %+v", got, want)
+    }
+    if p.done != nil {
+        t.Fatal("plugin that is not long-lived has a supervisor")
+    }
+
+    // Unsupported lifecycle phases succeed, but an unsupported execute
+    // fails like an unsupported invoke.
+    if err := p.PreLoad(); err != nil {
+        t.Fatalf("unsupported PreLoad: %v", err)
+    }
+    var pluginErr *PluginError
+    if err := p.Execute(); !errors.As(err, &pluginErr) || pluginErr.Op != "execute" {
+        t.Fatalf("unsupported Execute returned %v, want a PluginError", err)
+    }
+    if _, err := p.Invoke(context.Background(), InvokeRequest{Operation: "other"}); !errors.Is(err, ErrInvokeNotSupported) {
+        t.Fatalf("unsupported Invoke returned %v, want ErrInvokeNotSupported", err)
+    }
+    resp, err := p.Invoke(context.Background(), InvokeRequest{Operation: "greet"})
+    if err != nil || string(resp.Output) != `{"greeting":"hi"}` {
+        t.Fatalf("Invoke returned %s, %v", resp.Output, err)
+    }
+}
+
+func TestExecPluginExitCode(t *testing.T) {
+    p, _ := loadExecScript(t, 0, "failing.exec", `
+read -r req
+case "$req" in
+  *'"phase":"metadata"'*) echo '{"output":{"name":"failing","version":"1.0.0"}}' ;;
+  *'"phase":"init"'*) echo '{"error":"no database"}' ;;
+  *) exit 3 ;;
+esac
+`)
+
+    var pluginErr *PluginError
+    var exitErr *ExitError
+    err := p.Execute()
+    if !errors.As(err, &pluginErr) || pluginErr.Op != "execute" || !errors.As(err, &exitErr) || exitErr.Code != 3 {
+        t.Fatalf("Execute returned %v, want a PluginError wrapping exit code 3", err)
+    }
+    if err := p.Init(); !errors.As(err, &pluginErr) || pluginErr.Err.Error() != "no database" {
+        t.Fatalf("Init returned %v, want a PluginError with the plugin's message", err)
+    }
+}
+
+func TestExecPluginTimeoutKillsProcess(t *testing.T) {
+    p, _ := loadExecScript(t, 100*time.Millisecond, "sleepy.exec", `
+read -r req
+case "$req" in
+  *'"phase":"metadata"'*) echo '{"output":{"name":"sleepy","version":"1.0.0"}}' ;;
+  *) echo $$ > "$0.pid"; exec sleep 30 ;;
+esac
+`)
+
+    start := time.Now()
+    if err := p.Execute(); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("Execute returned %v, want context.DeadlineExceeded", err)
+    }
+    if elapsed := time.Since(start); elapsed > execWaitDelay {
+        t.Fatalf("Execute returned after %v", elapsed)
+    }
+    data, err := os.ReadFile(p.path + ".pid")
+    if err != nil {
+        t.Fatal(err)
+    }
+    pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+        t.Fatalf("plugin process %d still exists: %v", pid, err)
+    }
+}
+
+func TestLongLivedExecPluginAnswersByID(t *testing.T) {
+    p, _ := loadExecScript(t, 0, "server.exec", `
+while read -r req; do
+  id=$(echo "$req" | sed 's/.*"id":\([0-9]*\).*/\1/')
+  case "$req" in
+    *'"phase":"metadata"'*) echo '{"output":{"name":"server","version":"1.0.0","long_lived":true}}' ;;
+    *'"operation":"slow"'*) (sleep 0.3; echo "{\"id\":$id,\"output\":\"slow\"}") & ;;
+    *'"phase":"invoke"'*) echo "{\"id\":$id,\"output\":\"fast\"}" ;;
+    *) echo "{\"id\":$id}" ;;
+  esac
+done
+`)
+    if p.done == nil {
+        t.Fatal("long-lived plugin has no supervisor")
+    }
+
+    slow := make(chan string, 1)
+    go func() {
+        resp, err := p.Invoke(context.Background(), InvokeRequest{Operation: "slow"})
+        if err != nil {
+            slow <- err.Error()
+            return
+        }
+        slow <- string(resp.Output)
+    }()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        p.mu.Lock()
+        sent := p.nextID > 0
+        p.mu.Unlock()
+        if sent {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("slow request was never sent")
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    // The fast request is answered while the slow one is still pending.
+    resp, err := p.Invoke(context.Background(), InvokeRequest{Operation: "fast"})
+    if err != nil || string(resp.Output) != `"fast"` {
+        t.Fatalf("fast Invoke returned %s, %v", resp.Output, err)
+    }
+    select {
+    case got := <-slow:
+        t.Fatalf("slow Invoke returned %s before the fast one", got)
+    default:
+    }
+    if got := <-slow; got != `"slow"` {
+        t.Fatalf("slow Invoke returned %s", got)
+    }
+    if err := p.Execute(); err != nil {
+        t.Fatalf("Execute: %v", err)
+    }
+}
+
+func TestLongLivedExecPluginRestartsAndReplaysHooks(t *testing.T) {
+    p, logs := loadExecScript(t, 0, "crashy.exec", `
+while read -r req; do
+  id=$(echo "$req" | sed 's/.*"id":\([0-9]*\).*/\1/')
+  phase=$(echo "$req" | sed 's/.*"phase":"\([a-z]*\)".*/\1/')
+  case "$phase" in
+    metadata) echo '{"output":{"name":"crashy","version":"1.0.0","long_lived":true}}' ;;
+    execute) echo execute >> "$0.log"; exit 1 ;;
+    *) echo "$phase" >> "$0.log"; echo "{\"id\":$id}" ;;
+  esac
+done
+`)
+    for _, hook := range []func() error{p.PreLoad, p.Init, p.PostLoad} {
+        if err := hook(); err != nil {
+            t.Fatalf("load hook: %v", err)
+        }
+    }
+
+    var exitErr *ExitError
+    if err := p.Execute(); !errors.Is(err, ErrPluginExited) || !errors.As(err, &exitErr) || exitErr.Code != 1 {
+        t.Fatalf("Execute returned %v, want ErrPluginExited with exit code 1", err)
+    }
+    waitForLines(t, p.path+".log", 7)
+    if err := p.Execute(); !errors.Is(err, ErrPluginExited) {
+        t.Fatalf("Execute after a restart returned %v, want ErrPluginExited", err)
+    }
+    lines := waitForLines(t, p.path+".log", 11)
+
+    want := []string{"preload", "init", "postload", "execute", "preload", "init", "postload", "execute", "preload", "init", "postload"}
+    if !reflect.DeepEqual(lines, want) {
+        t.Fatalf("plugin ran phases %v, want %v", lines, want)
+    }
+    var backoffs []time.Duration
+    for _, entry := range logs.FilterMessage("Plugin process exited, restarting").All() {
+        backoffs = append(backoffs, entry.ContextMap()["backoff"].(time.Duration))
+    }
+    if !reflect.DeepEqual(backoffs, []time.Duration{execMinBackoff, 2 * execMinBackoff}) {
+        t.Fatalf("restarted after %v, want %v and %v", backoffs, execMinBackoff, 2*execMinBackoff)
+    }
+}
+
+func TestExecPluginStderrIsLogged(t *testing.T) {
+    p, logs := loadExecScript(t, 0, "noisy.exec", `
+read -r req
+case "$req" in
+  *'"phase":"metadata"'*) echo '{"output":{"name":"noisy","version":"1.0.0"}}' ;;
+  *) printf 'first\nsecond\nlast' >&2; echo '{}' ;;
+esac
+`)
+
+    if err := p.Execute(); err != nil {
+        t.Fatalf("Execute: %v", err)
+    }
+    var lines []string
+    for _, entry := range logs.FilterMessage("Plugin stderr").All() {
+        if entry.ContextMap()["plugin"] != "noisy.exec" {
+            t.Fatalf("stderr logged for plugin %v", entry.ContextMap()["plugin"])
+        }
+        lines = append(lines, entry.ContextMap()["line"].(string))
+    }
+    if want := []string{"first", "second", "last"}; !reflect.DeepEqual(lines, want) {
+        t.Fatalf("logged stderr lines %q, want %q", lines, want)
+    }
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "fmt"
+)
+
+// HostAPIVersion is the version of the contract between the manager and its
+// plugins: the Plugin interface, its optional extensions and the behaviour
+// the manager promises around them. Its major version changes whenever a
+// plugin written against the previous contract would misbehave.
+const HostAPIVersion = "1.0.0"
+
+// HostAPISymbol is the symbol under which a Go plugin may export the host
+// API constraint it was written against, as a string variable, so that the
+// manager can choose an adapter before using the plugin:
+//
+//    var HostAPI = "^1.0"
+const HostAPISymbol = "HostAPI"
+
+// legacyHostAPI is the host API constraint of plugins whose metadata does
+// not declare one, which were written against the first contract.
+const legacyHostAPI = "^1.0.0"
+
+// APIAdapter lets a manager serve plugins written against an earlier
+// generation of the host API. Adapt wraps such a plugin so that it behaves
+// as the current contract expects, for example by translating the results
+// of its hooks or supplying extensions it predates.
+//
+// Plugins opened by a SymbolLoader are passed to Adapt as the value they
+// export, which need not implement the current Plugin interface, if their
+// manifest or HostAPISymbol declares a host API version the adapter serves
+// or, when they declare none, if the value does not implement Plugin. Other
+// plugins are passed as a Plugin once their metadata has been read.
+// The manager finds optional interfaces such as io.Closer or
+// StatefulPlugin on the adapted plugin, so an adapter should forward those
+// the original implements.
+type APIAdapter interface {
+    // APIVersion returns the host API version the adapter serves.
+    APIVersion() string
+    Adapt(plugin any) (Plugin, error)
+}
+
+// HostAPIError reports that a plugin requires a host API version the
+// manager neither provides nor serves through an adapter. It matches
+// ErrIncompatibleHostAPI.
+type HostAPIError struct {
+    Plugin    string
+    Required  string
+    Host      string
+    Supported string
+}
+
+func (e *HostAPIError) Error() string {
+    return fmt.Sprintf("plugin %s requires host API %s, host provides %s and supports %s", e.Plugin, e.Required, e.Host, e.Supported)
+}
+
+func (e *HostAPIError) Unwrap() error {
+    return ErrIncompatibleHostAPI
+}
+
+// hostAPI is the host API a manager provides: its own version, the range of
+// versions it accepts plugins for and the adapters for older versions.
+type hostAPI struct {
+    version   Version
+    supported *Constraint
+    adapters  []hostAdapter
+}
+
+type hostAdapter struct {
+    version Version
+    adapter APIAdapter
+}
+
+// newHostAPI parses a host API version and supported range. An empty range
+// supports the versions compatible with the host's own, ^version.
+func newHostAPI(version, supported string, adapters []APIAdapter) (*hostAPI, error) {
+    v, err := ParseVersion(version)
+    if err != nil {
+        return nil, fmt.Errorf("host API version: %w", err)
+    }
+    if supported == "" {
+        supported = "^" + v.String()
+    }
+    c, err := ParseConstraint(supported)
+    if err != nil {
+        return nil, fmt.Errorf("supported host API range: %w", err)
+    }
+
+    api := &hostAPI{version: v, supported: c}
+    for _, adapter := range adapters {
+        av, err := ParseVersion(adapter.APIVersion())
+        if err != nil {
+            return nil, fmt.Errorf("host API adapter: %w", err)
+        }
+        api.adapters = append(api.adapters, hostAdapter{av, adapter})
+    }
+    return api, nil
+}
+
+// negotiate returns the highest host API version that the manager supports
+// and the required constraint accepts, together with the adapter serving
+// it, which is nil for the host's own version.
+func (api *hostAPI) negotiate(plugin, required string) (Version, APIAdapter, error) {
+    if required == "" {
+        required = legacyHostAPI
+    }
+    c, err := ParseConstraint(required)
+    if err != nil {
+        return Version{}, nil, fmt.Errorf("%w: plugin %s: %w", ErrIncompatibleHostAPI, plugin, err)
+    }
+
+    var best *hostAdapter
+    if api.supported.Check(api.version) && c.Check(api.version) {
+        best = &hostAdapter{version: api.version}
+    }
+    for i := range api.adapters {
+        candidate := &api.adapters[i]
+        if !api.supported.Check(candidate.version) || !c.Check(candidate.version) {
+            continue
+        }
+        if best == nil || candidate.version.Compare(best.version) > 0 {
+            best = candidate
+        }
+    }
+    if best == nil {
+        return Version{}, nil, &HostAPIError{
+            Plugin:    plugin,
+            Required:  required,
+            Host:      api.version.String(),
+            Supported: api.supported.String(),
+        }
+    }
+    return best.version, best.adapter, nil
+}
+
+// adaptSymbol negotiates the host API of a plugin from the value it exports
+// and the constraint it declares outside its metadata, and returns the value
+// as a Plugin, wrapped in an adapter if one serves the negotiated version.
+// A value that implements Plugin and declares no constraint is returned as
+// it is, to be negotiated from its metadata; adapted reports whether it was
+// not.
+func (api *hostAPI) adaptSymbol(name string, symbol any, required string) (plugin Plugin, adapted bool, err error) {
+    if required == "" {
+        if plugin, ok := symbol.(Plugin); ok {
+            return plugin, false, nil
+        }
+    }
+    version, adapter, err := api.negotiate(name, required)
+    if err != nil {
+        return nil, false, err
+    }
+    if adapter == nil {
+        plugin, ok := symbol.(Plugin)
+        if !ok {
+            return nil, false, &PluginError{Op: "assert", Plugin: name, Err: ErrInvalidPluginInterface}
+        }
+        return plugin, false, nil
+    }
+    plugin, err = adapter.Adapt(symbol)
+    if err != nil {
+        return nil, false, fmt.Errorf("host API %s adapter: %w", version, err)
+    }
+    return plugin, true, nil
+}
+
+// adapt negotiates the host API of an opened plugin and wraps it in the
+// adapter for the negotiated version, if it is not the host's own.
+func (api *hostAPI) adapt(name string, plugin Plugin, metadata PluginMetadata) (Plugin, error) {
+    version, adapter, err := api.negotiate(name, metadata.HostAPI)
+    if err != nil || adapter == nil {
+        return plugin, err
+    }
+    adapted, err := adapter.Adapt(plugin)
+    if err != nil {
+        return nil, fmt.Errorf("host API %s adapter: %w", version, err)
+    }
+    return adapted, nil
+}
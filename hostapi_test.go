@@ -0,0 +1,119 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// legacyPlugin implements an older plugin interface generation that has no
+// lifecycle hooks besides Run.
+type legacyPlugin struct {
+    name string
+    runs int
+}
+
+func (p *legacyPlugin) Describe() (string, string) { return p.name, "1.0.0" }
+func (p *legacyPlugin) Run() error                 { p.runs++; return nil }
+
+// legacyAdapter serves host API 0.9.0 by wrapping legacyPlugin values.
+type legacyAdapter struct{}
+
+func (legacyAdapter) APIVersion() string { return "0.9.0" }
+
+func (legacyAdapter) Adapt(plugin any) (Plugin, error) {
+    legacy, ok := plugin.(*legacyPlugin)
+    if !ok {
+        return nil, fmt.Errorf("unexpected plugin type %T", plugin)
+    }
+    return &adaptedLegacyPlugin{legacy}, nil
+}
+
+type adaptedLegacyPlugin struct {
+    legacy *legacyPlugin
+}
+
+func (p *adaptedLegacyPlugin) Metadata() PluginMetadata {
+    name, version := p.legacy.Describe()
+    return PluginMetadata{Name: name, Version: version, HostAPI: "^0.9.0"}
+}
+
+func (p *adaptedLegacyPlugin) PreLoad() error   { return nil }
+func (p *adaptedLegacyPlugin) Init() error      { return nil }
+func (p *adaptedLegacyPlugin) PostLoad() error  { return nil }
+func (p *adaptedLegacyPlugin) Execute() error   { return p.legacy.Run() }
+func (p *adaptedLegacyPlugin) PreUnload() error { return nil }
+func (p *adaptedLegacyPlugin) Shutdown() error  { return nil }
+
+// symbolLoader serves "symbol:" paths from a map, like a Go plugin exporting
+// Plugin and HostAPI symbols.
+type symbolLoader struct {
+    symbols map[string]any
+    hostAPI map[string]string
+}
+
+func (l symbolLoader) Match(path string) bool {
+    return strings.HasPrefix(path, "symbol:")
+}
+
+func (l symbolLoader) Load(path string) (Plugin, error) {
+    symbol, _, err := l.LoadSymbol(path)
+    if err != nil {
+        return nil, err
+    }
+    plugin, ok := symbol.(Plugin)
+    if !ok {
+        return nil, ErrInvalidPluginInterface
+    }
+    return plugin, nil
+}
+
+func (l symbolLoader) LoadSymbol(path string) (any, string, error) {
+    name := strings.TrimPrefix(path, "symbol:")
+    return l.symbols[name], l.hostAPI[name], nil
+}
+
+func TestAdapterServesOlderInterfaceGeneration(t *testing.T) {
+    legacy := &legacyPlugin{name: "Legacy"}
+    undeclared := &legacyPlugin{name: "Undeclared"}
+    loader := symbolLoader{
+        symbols: map[string]any{"legacy": legacy, "undeclared": undeclared, "future": &testPlugin{name: "Future"}},
+        hostAPI: map[string]string{"legacy": "^0.9", "future": "^3.0"},
+    }
+    m := newTestManager(t,
+        WithLoader(loader),
+        WithVerifier(nopVerifier{}),
+        WithHostAPI("1.0.0", ">=0.9.0 <2.0.0"),
+        WithAPIAdapter(legacyAdapter{}),
+    )
+
+    if err := m.LoadPlugin("symbol:legacy"); err != nil {
+        t.Fatalf("LoadPlugin of an older generation: %v", err)
+    }
+    if err := m.ExecutePlugin("Legacy"); err != nil {
+        t.Fatalf("ExecutePlugin: %v", err)
+    }
+    if legacy.runs != 1 {
+        t.Fatalf("legacy plugin ran %d times, want 1", legacy.runs)
+    }
+
+    // Without a declared version the plugin is taken to require ^1.0.0,
+    // which the host serves itself.
+    if err := m.LoadPlugin("symbol:undeclared"); !errors.Is(err, ErrInvalidPluginInterface) {
+        t.Fatalf("LoadPlugin without a declared host API returned %v, want ErrInvalidPluginInterface", err)
+    }
+
+    err := m.LoadPlugin("symbol:future")
+    var apiErr *HostAPIError
+    if !errors.As(err, &apiErr) || !errors.Is(err, ErrIncompatibleHostAPI) {
+        t.Fatalf("LoadPlugin of a newer generation returned %v, want a HostAPIError", err)
+    }
+    if apiErr.Required != "^3.0" || apiErr.Host != "1.0.0" {
+        t.Fatalf("HostAPIError is %+v", apiErr)
+    }
+}
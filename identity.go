@@ -0,0 +1,286 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+
+    "go.uber.org/zap"
+)
+
+// A plugin's identity is the Name in its metadata. The config, aliases and
+// events use that name; the registry, dependencies and stats use the key of
+// each loaded version, name@version (see versions.go). Paths are only where a
+// plugin is loaded from. Until a plugin without a manifest has been opened,
+// its name is not known and it is registered under its file name without
+// extension, or its static registration name, instead.
+
+// AddAlias makes alias refer to the plugin called name in every API that
+// takes a plugin name. Names of registered plugins take precedence over
+// aliases. AddAlias fails with ErrDuplicatePluginName if alias is already a
+// plugin name or an alias of another plugin.
+func (m *Manager) AddAlias(alias, name string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.addAliasLocked(alias, name)
+}
+
+// RemoveAlias removes an alias added with AddAlias or by a manifest.
+func (m *Manager) RemoveAlias(alias string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.aliases, alias)
+}
+
+// addAliasLocked records an alias. The caller must hold m.mu.
+func (m *Manager) addAliasLocked(alias, name string) error {
+    if alias == name {
+        return nil
+    }
+    if existing, exists := m.plugins[alias]; exists {
+        return fmt.Errorf("%w: %s is the name of the plugin at %s", ErrDuplicatePluginName, alias, existing.path)
+    }
+    if existing := m.versionsLocked(alias); len(existing) > 0 {
+        return fmt.Errorf("%w: %s is the name of the plugin at %s", ErrDuplicatePluginName, alias, existing[0].path)
+    }
+    if target, exists := m.aliases[alias]; exists && target != name {
+        return fmt.Errorf("%w: %s is already an alias of %s", ErrDuplicatePluginName, alias, target)
+    }
+    m.aliases[alias] = name
+    return nil
+}
+
+// lookupLocked returns the plugin registered under ref, which is a plugin
+// name or alias, optionally qualified as name@version. An unqualified name
+// refers to the plugin's default version. The caller must hold m.mu.
+func (m *Manager) lookupLocked(ref string) (*pluginEntry, bool) {
+    if entry, exists := m.plugins[ref]; exists {
+        return entry, true
+    }
+    name, version := splitPluginKey(ref)
+    if target, exists := m.aliases[name]; exists {
+        name = target
+    }
+    if version != "" {
+        entry, exists := m.plugins[pluginKey(name, version)]
+        return entry, exists
+    }
+    return m.defaultVersionLocked(name)
+}
+
+// canonicalName returns the plugin name that ref refers to, resolving
+// aliases and dropping any version. Unknown names are returned unchanged.
+func (m *Manager) canonicalName(ref string) string {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.canonicalNameLocked(ref)
+}
+
+// canonicalNameLocked is canonicalName for callers holding m.mu.
+func (m *Manager) canonicalNameLocked(ref string) string {
+    if entry, exists := m.plugins[ref]; exists {
+        return entry.name
+    }
+    name, _ := splitPluginKey(ref)
+    if target, exists := m.aliases[name]; exists {
+        return target
+    }
+    return name
+}
+
+// claimKey makes key available to the plugin at path. A plugin that failed
+// is replaced, unless another operation is working on it; any other plugin
+// registered under key is refused. Other versions of the same plugin are
+// not affected. The caller must hold m.mu.
+func (m *Manager) claimKey(key, path string) error {
+    existing, exists := m.plugins[key]
+    if !exists {
+        return nil
+    }
+
+    if state, _ := existing.currentState(); state != StateFailed || !existing.mu.TryLock() {
+        if existing.path == path {
+            return fmt.Errorf("%w: %s", ErrPluginAlreadyLoaded, key)
+        }
+        return fmt.Errorf("%w: %s is claimed by both %s and %s", ErrDuplicatePluginName, key, existing.path, path)
+    }
+    m.removePlugin(key)
+    existing.mu.Unlock()
+    // Closing may wait for a plugin process to exit, which must not happen
+    // under the registry lock.
+    go m.closePlugin(existing)
+    return nil
+}
+
+// renameEntry moves a registered entry from its provisional key to the key
+// of the name and version its plugin reports. Entries that are not
+// registered, such as the incoming version of a hot-reload, are left alone.
+// The caller must hold entry.mu.
+func (m *Manager) renameEntry(entry *pluginEntry, name, version string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := pluginKey(name, version)
+    if name == "" || key == entry.key || m.plugins[entry.key] != entry {
+        return nil
+    }
+    if err := m.claimKey(key, entry.path); err != nil {
+        return err
+    }
+
+    m.deleteEntryLocked(entry.key)
+    entry.stateMu.Lock()
+    entry.key, entry.name, entry.version = key, name, version
+    entry.stateMu.Unlock()
+    m.putEntryLocked(entry)
+    return nil
+}
+
+// registerAliases records the aliases listed in a plugin's manifest. The
+// caller must hold m.mu.
+func (m *Manager) registerAliases(entry *pluginEntry) error {
+    if entry.manifest == nil {
+        return nil
+    }
+    for i, alias := range entry.manifest.Aliases {
+        if err := m.addAliasLocked(alias, entry.name); err != nil {
+            for _, added := range entry.manifest.Aliases[:i] {
+                delete(m.aliases, added)
+            }
+            return err
+        }
+    }
+    return nil
+}
+
+// unregisterAliases removes the aliases listed in a plugin's manifest. The
+// caller must hold m.mu.
+func (m *Manager) unregisterAliases(entry *pluginEntry) {
+    if entry.manifest == nil {
+        return
+    }
+    for _, alias := range entry.manifest.Aliases {
+        if m.aliases[alias] == entry.name && !m.aliasClaimedLocked(alias, entry) {
+            delete(m.aliases, alias)
+        }
+    }
+}
+
+// aliasClaimedLocked reports whether a version of entry's plugin other than
+// entry lists alias in its manifest. The caller must hold m.mu.
+func (m *Manager) aliasClaimedLocked(alias string, entry *pluginEntry) bool {
+    for _, other := range m.versionsLocked(entry.name) {
+        if other != entry && other.manifest != nil && slices.Contains(other.manifest.Aliases, alias) {
+            return true
+        }
+    }
+    return false
+}
+
+// expectedKey returns the key a plugin is registered under before it is
+// opened: the name and version in its manifest, or else the name derived
+// from its path.
+func expectedKey(path string, manifest *Manifest) string {
+    if manifest != nil && manifest.Name != "" {
+        return pluginKey(manifest.Name, manifest.Version)
+    }
+    return nameFromPath(path)
+}
+
+// indexPlugins maps the plugin names, keys and aliases found in dir to the
+// plugins that claim them. Names come from manifests and bundles, or from
+// the file name of plugins without a manifest. A name claimed by a manifest
+// is not also claimed by a file of the same name without one.
+func (m *Manager) indexPlugins(dir string) (map[string][]string, error) {
+    entries, err := os.ReadDir(dir)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    declared := make(map[string][]string)
+    derived := make(map[string][]string)
+    for _, dirEntry := range entries {
+        path := filepath.Join(dir, dirEntry.Name())
+        if dirEntry.IsDir() && !isBundle(path) {
+            continue
+        }
+        if !dirEntry.IsDir() {
+            if _, err := m.loaderFor(path); err != nil {
+                continue
+            }
+        }
+
+        binary, manifest, err := resolvePlugin(path)
+        if err != nil {
+            m.logger.Warn("Skipping plugin with unreadable manifest", zap.String("path", path), zap.Error(err))
+            continue
+        }
+        if manifest == nil {
+            name := nameFromPath(binary)
+            derived[name] = append(derived[name], path)
+            continue
+        }
+        name := manifest.Name
+        if name == "" {
+            name = nameFromPath(binary)
+        }
+        names := append([]string{name}, manifest.Aliases...)
+        if manifest.Version != "" {
+            names = append(names, pluginKey(name, manifest.Version))
+        }
+        for _, name := range names {
+            declared[name] = append(declared[name], path)
+        }
+    }
+
+    for name, paths := range derived {
+        if _, ok := declared[name]; !ok {
+            declared[name] = paths
+        }
+    }
+    for _, paths := range declared {
+        sort.Strings(paths)
+    }
+    return declared, nil
+}
+
+// recordPaths records in the config where the loaded versions of the plugin
+// called name were loaded from. Plugins without a manifest and static
+// plugins cannot be found by the name in their metadata before they are
+// opened, so LoadEnabledPlugins falls back to these paths.
+func (m *Manager) recordPaths(name string) error {
+    m.mu.RLock()
+    var paths []string
+    for _, entry := range m.versionsLocked(name) {
+        paths = append(paths, entry.path)
+    }
+    m.mu.RUnlock()
+
+    if len(paths) == 0 {
+        return nil
+    }
+    return m.config.SetPluginPaths(name, paths)
+}
+
+// locatePlugin returns the paths of the plugins called name in an index
+// built by indexPlugins. A name claimed by several plugins returns them all,
+// so that every version of a plugin is loaded; versions claimed twice are
+// refused when the plugins are registered.
+func locatePlugin(index map[string][]string, dir, name string) ([]string, error) {
+    paths := index[name]
+    if len(paths) == 0 {
+        return nil, fmt.Errorf("%w: %s is not in %s", ErrPluginNotFound, name, dir)
+    }
+    return paths, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "go.uber.org/zap"
+)
+
+// fileLoader opens ".test" files as the plugins mapped to their file name.
+type fileLoader struct {
+    plugins map[string]*testPlugin
+}
+
+func (l fileLoader) Match(path string) bool {
+    return filepath.Ext(path) == ".test"
+}
+
+func (l fileLoader) Load(path string) (Plugin, error) {
+    return l.plugins[filepath.Base(path)], nil
+}
+
+// writeTestPlugin writes an empty plugin file called name to dir, with a
+// manifest for p unless p is nil, and returns its path.
+func writeTestPlugin(t *testing.T, dir, name string, p *testPlugin) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    if p != nil {
+        manifest, err := json.Marshal(Manifest{Name: p.name, Version: p.Metadata().Version, Dependencies: p.deps})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path+".json", manifest, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return path
+}
+
+func TestEnabledPluginsLoadByMetadataName(t *testing.T) {
+    dir := t.TempDir()
+    file := writeTestPlugin(t, dir, "hello-rt.test", nil)
+    static := registerTestPlugin(&testPlugin{name: "StaticRT"})
+    loader := fileLoader{plugins: map[string]*testPlugin{"hello-rt.test": {name: "HelloRT"}}}
+
+    newManager := func() *Manager {
+        m, err := NewManager(filepath.Join(dir, "plugins.json"), dir, "",
+            WithSandbox(nopSandbox{}),
+            WithLogger(zap.NewNop()),
+            WithVerifier(nopVerifier{}),
+            WithLoader(loader),
+        )
+        if err != nil {
+            t.Fatalf("NewManager: %v", err)
+        }
+        return m
+    }
+
+    first := newManager()
+    if err := first.LoadPlugins([]string{file, static}); err != nil {
+        t.Fatalf("LoadPlugins: %v", err)
+    }
+    for _, name := range []string{"HelloRT", "StaticRT"} {
+        if err := first.EnablePlugin(name); err != nil {
+            t.Fatalf("EnablePlugin %s: %v", name, err)
+        }
+    }
+    if err := first.Close(); err != nil {
+        t.Fatalf("Close: %v", err)
+    }
+
+    // The next startup finds the plugins by the names the config uses,
+    // although neither has a manifest or is registered under that name.
+    second := newManager()
+    defer second.Close()
+    if err := second.LoadEnabledPlugins(dir); err != nil {
+        t.Fatalf("LoadEnabledPlugins: %v", err)
+    }
+    for _, name := range []string{"HelloRT", "StaticRT"} {
+        if state, err := second.State(name); err != nil || state != StateRunning {
+            t.Fatalf("%s is %s (%v), want running", name, state, err)
+        }
+    }
+    if !strings.HasPrefix(second.config.PluginPaths("StaticRT")[0], StaticPrefix) {
+        t.Fatalf("StaticRT has paths %v", second.config.PluginPaths("StaticRT"))
+    }
+}
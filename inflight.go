@@ -0,0 +1,112 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license 
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+// errInstanceRetired is returned to executions that were waiting on a plugin
+// instance which has since been unloaded or replaced. The caller looks the
+// plugin up again to reach the replacement, if any.
+var errInstanceRetired = errors.New("plugin instance retired")
+
+// inflightTracker counts the executions running against one plugin instance
+// and lets unload and hot-reload hold new executions back while they wait for
+// the running ones to finish.
+type inflightTracker struct {
+    mu      sync.Mutex
+    count   int
+    paused  chan struct{} // closed when new executions may proceed again
+    idle    chan struct{} // closed when count drops to zero while draining
+    retired bool
+}
+
+// acquire registers an execution, waiting while the instance is paused.
+func (t *inflightTracker) acquire(ctx context.Context) error {
+    t.mu.Lock()
+    for t.paused != nil && !t.retired {
+        paused := t.paused
+        t.mu.Unlock()
+
+        select {
+        case <-paused:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+
+        t.mu.Lock()
+    }
+    defer t.mu.Unlock()
+
+    if t.retired {
+        return errInstanceRetired
+    }
+    t.count++
+    return nil
+}
+
+func (t *inflightTracker) release() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.count--
+    if t.count == 0 && t.idle != nil {
+        close(t.idle)
+        t.idle = nil
+    }
+}
+
+// drain pauses new executions and waits until the running ones finish. If
+// ctx ends first, the instance is resumed and ctx's error is returned.
+func (t *inflightTracker) drain(ctx context.Context) error {
+    t.mu.Lock()
+    if t.paused == nil {
+        t.paused = make(chan struct{})
+    }
+    if t.count == 0 {
+        t.mu.Unlock()
+        return nil
+    }
+    if t.idle == nil {
+        t.idle = make(chan struct{})
+    }
+    idle := t.idle
+    t.mu.Unlock()
+
+    select {
+    case <-idle:
+        return nil
+    case <-ctx.Done():
+        t.resume()
+        return ctx.Err()
+    }
+}
+
+// resume lets executions held back by drain proceed on this instance.
+func (t *inflightTracker) resume() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.paused != nil {
+        close(t.paused)
+        t.paused = nil
+    }
+}
+
+// retire marks the instance as gone. Executions held back by drain give up
+// with errInstanceRetired.
+func (t *inflightTracker) retire() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.retired = true
+    if t.paused != nil {
+        close(t.paused)
+        t.paused = nil
+    }
+}
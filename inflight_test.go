@@ -0,0 +1,391 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// testPlugin is a statically registered plugin whose hooks can be made to
+// block or fail, and which records what it observed.
+type testPlugin struct {
+    name    string
+    version string
+    deps    map[string]string
+    init    func() error
+    execute func() error
+
+    executions        atomic.Int32
+    running           atomic.Int32
+    shutdowns         atomic.Int32
+    runningAtShutdown atomic.Int32
+}
+
+func (p *testPlugin) Metadata() PluginMetadata {
+    version := p.version
+    if version == "" {
+        version = "1.0.0"
+    }
+    return PluginMetadata{Name: p.name, Version: version, Dependencies: p.deps}
+}
+
+func (p *testPlugin) PreLoad() error   { return nil }
+func (p *testPlugin) PostLoad() error  { return nil }
+func (p *testPlugin) PreUnload() error { return nil }
+
+func (p *testPlugin) Init() error {
+    if p.init != nil {
+        return p.init()
+    }
+    return nil
+}
+
+func (p *testPlugin) Execute() error {
+    p.running.Add(1)
+    defer p.running.Add(-1)
+    p.executions.Add(1)
+    if p.execute != nil {
+        return p.execute()
+    }
+    return nil
+}
+
+func (p *testPlugin) Shutdown() error {
+    p.runningAtShutdown.Store(p.running.Load())
+    p.shutdowns.Add(1)
+    return nil
+}
+
+type nopSandbox struct{}
+
+func (nopSandbox) Enable() error                 { return nil }
+func (nopSandbox) Disable() error                { return nil }
+func (nopSandbox) VerifyPluginPath(string) error { return nil }
+
+type nopVerifier struct{}
+
+func (nopVerifier) Verify(string) error { return nil }
+
+var staticSeq atomic.Int64
+
+// registerTestPlugin registers p under a name unique to this test run and
+// returns its static path.
+func registerTestPlugin(p *testPlugin) string {
+    name := fmt.Sprintf("%s-%d", p.name, staticSeq.Add(1))
+    Register(name, func() Plugin { return p })
+    return StaticPrefix + name
+}
+
+func newTestManager(t testing.TB, opts ...Option) *Manager {
+    t.Helper()
+    dir := t.TempDir()
+    opts = append([]Option{WithSandbox(nopSandbox{}), WithLogger(zap.NewNop())}, opts...)
+    m, err := NewManager(filepath.Join(dir, "plugins.json"), dir, "", opts...)
+    if err != nil {
+        t.Fatalf("NewManager: %v", err)
+    }
+    return m
+}
+
+func loadTestPlugin(t testing.TB, m *Manager, p *testPlugin) {
+    t.Helper()
+    if err := m.LoadPlugin(registerTestPlugin(p)); err != nil {
+        t.Fatalf("LoadPlugin %s: %v", p.name, err)
+    }
+}
+
+// blockingExecute returns an Execute hook that closes started when it is
+// first called and blocks until release is closed.
+func blockingExecute(started chan struct{}, release <-chan struct{}) func() error {
+    var once sync.Once
+    return func() error {
+        once.Do(func() { close(started) })
+        <-release
+        return nil
+    }
+}
+
+// waitDraining waits until executions of the plugin registered under key are
+// being held back.
+func waitDraining(t *testing.T, m *Manager, key string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for time.Now().Before(deadline) {
+        m.mu.RLock()
+        entry := m.plugins[key]
+        m.mu.RUnlock()
+        if entry != nil {
+            entry.inflight.mu.Lock()
+            paused := entry.inflight.paused != nil
+            entry.inflight.mu.Unlock()
+            if paused {
+                return
+            }
+        }
+        time.Sleep(time.Millisecond)
+    }
+    t.Fatalf("%s was never drained", key)
+}
+
+func TestUnloadWaitsForExecution(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    p := &testPlugin{name: "UnloadWait", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, p)
+
+    executed := make(chan error, 1)
+    go func() { executed <- m.ExecutePlugin("UnloadWait") }()
+    <-started
+
+    unloaded := make(chan error, 1)
+    go func() { unloaded <- m.UnloadPlugin("UnloadWait") }()
+    waitDraining(t, m, "UnloadWait@1.0.0")
+
+    select {
+    case err := <-unloaded:
+        t.Fatalf("unload finished during an execution: %v", err)
+    case <-time.After(20 * time.Millisecond):
+    }
+
+    close(release)
+    if err := <-executed; err != nil {
+        t.Fatalf("ExecutePlugin: %v", err)
+    }
+    if err := <-unloaded; err != nil {
+        t.Fatalf("UnloadPlugin: %v", err)
+    }
+    if n := p.runningAtShutdown.Load(); n != 0 {
+        t.Fatalf("Shutdown ran with %d executions in progress", n)
+    }
+}
+
+func TestUnloadWaitsForAbandonedExecution(t *testing.T) {
+    m := newTestManager(t)
+    p := &testPlugin{name: "Abandoned", execute: func() error {
+        time.Sleep(200 * time.Millisecond)
+        return nil
+    }}
+    loadTestPlugin(t, m, p)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := m.ExecutePluginContext(ctx, "Abandoned"); !errors.Is(err, ErrPluginTimeout) {
+        t.Fatalf("ExecutePluginContext returned %v, want ErrPluginTimeout", err)
+    }
+    if p.running.Load() != 1 {
+        t.Fatal("plugin is no longer executing")
+    }
+
+    if err := m.UnloadPlugin("Abandoned"); err != nil {
+        t.Fatalf("UnloadPlugin: %v", err)
+    }
+    if n := p.runningAtShutdown.Load(); n != 0 {
+        t.Fatalf("Shutdown ran with %d executions in progress", n)
+    }
+}
+
+func TestShutdownWaitsForAbandonedExecution(t *testing.T) {
+    m := newTestManager(t)
+    p := &testPlugin{name: "ShutdownAbandoned", execute: func() error {
+        time.Sleep(200 * time.Millisecond)
+        return nil
+    }}
+    loadTestPlugin(t, m, p)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := m.ExecutePluginContext(ctx, "ShutdownAbandoned"); !errors.Is(err, ErrPluginTimeout) {
+        t.Fatalf("ExecutePluginContext returned %v, want ErrPluginTimeout", err)
+    }
+
+    if err := m.Shutdown(context.Background()); err != nil {
+        t.Fatalf("Shutdown: %v", err)
+    }
+    if n := p.runningAtShutdown.Load(); n != 0 {
+        t.Fatalf("plugin Shutdown ran with %d executions in progress", n)
+    }
+}
+
+func TestHotReloadWaitsForExecution(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    oldVersion := &testPlugin{name: "ReloadWait", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, oldVersion)
+    newVersion := &testPlugin{name: "ReloadWait", version: "1.1.0"}
+
+    executed := make(chan error, 1)
+    go func() { executed <- m.ExecutePlugin("ReloadWait") }()
+    <-started
+
+    reloaded := make(chan error, 1)
+    go func() { reloaded <- m.HotReload("ReloadWait", registerTestPlugin(newVersion)) }()
+    waitDraining(t, m, "ReloadWait@1.0.0")
+
+    close(release)
+    if err := <-executed; err != nil {
+        t.Fatalf("ExecutePlugin: %v", err)
+    }
+    if err := <-reloaded; err != nil {
+        t.Fatalf("HotReload: %v", err)
+    }
+    if oldVersion.shutdowns.Load() != 1 {
+        t.Fatal("old version was not shut down")
+    }
+    if n := oldVersion.runningAtShutdown.Load(); n != 0 {
+        t.Fatalf("old version was shut down with %d executions in progress", n)
+    }
+}
+
+func TestExecutionDuringHotReloadRunsNewVersion(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    oldVersion := &testPlugin{name: "ReloadRoute", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, oldVersion)
+    newVersion := &testPlugin{name: "ReloadRoute", version: "2.0.0"}
+
+    first := make(chan error, 1)
+    go func() { first <- m.ExecutePlugin("ReloadRoute") }()
+    <-started
+
+    reloaded := make(chan error, 1)
+    go func() { reloaded <- m.HotReload("ReloadRoute", registerTestPlugin(newVersion)) }()
+    waitDraining(t, m, "ReloadRoute@1.0.0")
+
+    second := make(chan error, 1)
+    go func() { second <- m.ExecutePlugin("ReloadRoute") }()
+    select {
+    case err := <-second:
+        t.Fatalf("execution was not held back during the reload: %v", err)
+    case <-time.After(20 * time.Millisecond):
+    }
+
+    close(release)
+    if err := <-first; err != nil {
+        t.Fatalf("first ExecutePlugin: %v", err)
+    }
+    if err := <-reloaded; err != nil {
+        t.Fatalf("HotReload: %v", err)
+    }
+    if err := <-second; err != nil {
+        t.Fatalf("second ExecutePlugin: %v", err)
+    }
+
+    if n := oldVersion.executions.Load(); n != 1 {
+        t.Fatalf("old version ran %d executions, want 1", n)
+    }
+    if n := newVersion.executions.Load(); n != 1 {
+        t.Fatalf("new version ran %d executions, want 1", n)
+    }
+}
+
+func TestUnloadDrainTimeout(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    p := &testPlugin{name: "UnloadTimeout", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, p)
+
+    executed := make(chan error, 1)
+    go func() { executed <- m.ExecutePlugin("UnloadTimeout") }()
+    <-started
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := m.UnloadPluginContext(ctx, "UnloadTimeout"); !errors.Is(err, ErrPluginTimeout) {
+        t.Fatalf("UnloadPluginContext returned %v, want ErrPluginTimeout", err)
+    }
+    if state, _ := m.State("UnloadTimeout"); state != StateRunning {
+        t.Fatalf("plugin is %s after a failed unload, want running", state)
+    }
+
+    close(release)
+    if err := <-executed; err != nil {
+        t.Fatalf("ExecutePlugin: %v", err)
+    }
+    if err := m.ExecutePlugin("UnloadTimeout"); err != nil {
+        t.Fatalf("ExecutePlugin after a failed unload: %v", err)
+    }
+    if p.shutdowns.Load() != 0 {
+        t.Fatal("plugin was shut down by a failed unload")
+    }
+}
+
+func TestHotReloadDrainTimeout(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    oldVersion := &testPlugin{name: "ReloadTimeout", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, oldVersion)
+    newVersion := &testPlugin{name: "ReloadTimeout", version: "1.1.0"}
+    m.SetPluginTimeout("ReloadTimeout", 20*time.Millisecond)
+
+    executed := make(chan error, 1)
+    go func() { executed <- m.ExecutePluginContext(context.Background(), "ReloadTimeout") }()
+    <-started
+
+    failed := make(chan PluginHotReloadFailedEvent, 1)
+    m.SubscribeToEvent("PluginHotReloadFailed", func(e Event) {
+        failed <- e.(PluginHotReloadFailedEvent)
+    })
+
+    if err := m.HotReload("ReloadTimeout", registerTestPlugin(newVersion)); !errors.Is(err, ErrPluginTimeout) {
+        t.Fatalf("HotReload returned %v, want ErrPluginTimeout", err)
+    }
+    select {
+    case <-failed:
+    case <-time.After(5 * time.Second):
+        t.Fatal("no PluginHotReloadFailed event")
+    }
+    if versions := m.PluginVersions("ReloadTimeout"); len(versions) != 1 || versions[0] != "1.0.0" {
+        t.Fatalf("versions after a failed reload are %v, want [1.0.0]", versions)
+    }
+    if newVersion.shutdowns.Load() != 1 {
+        t.Fatal("rejected new version was not shut down")
+    }
+
+    close(release)
+    <-executed
+    if err := m.ExecutePlugin("ReloadTimeout"); err != nil {
+        t.Fatalf("ExecutePlugin after a failed reload: %v", err)
+    }
+    if n := oldVersion.executions.Load(); n != 2 {
+        t.Fatalf("old version ran %d executions, want 2", n)
+    }
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+    m := newTestManager(t)
+    started, release := make(chan struct{}), make(chan struct{})
+    p := &testPlugin{name: "ShutdownTimeout", execute: blockingExecute(started, release)}
+    loadTestPlugin(t, m, p)
+
+    executed := make(chan error, 1)
+    go func() { executed <- m.ExecutePlugin("ShutdownTimeout") }()
+    <-started
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("Shutdown returned %v, want a deadline error", err)
+    }
+    if p.shutdowns.Load() != 0 {
+        t.Fatal("plugin was shut down during an execution")
+    }
+
+    close(release)
+    if err := <-executed; err != nil {
+        t.Fatalf("ExecutePlugin: %v", err)
+    }
+    if err := m.ExecutePlugin("ShutdownTimeout"); !errors.Is(err, ErrManagerClosed) {
+        t.Fatalf("ExecutePlugin after Shutdown returned %v, want ErrManagerClosed", err)
+    }
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license 
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+// InvokeRequest is the envelope passed to a plugin's Invoke method. It is
+// plain JSON so that the same request can be handed to an in-process plugin
+// or serialized to a plugin running in another process.
+type InvokeRequest struct {
+    Plugin    string          `json:"plugin"`
+    Operation string          `json:"operation,omitempty"`
+    Input     json.RawMessage `json:"input,omitempty"`
+}
+
+// Decode unmarshals the request input into v.
+func (r InvokeRequest) Decode(v any) error {
+    if len(r.Input) == 0 {
+        return nil
+    }
+    return json.Unmarshal(r.Input, v)
+}
+
+// InvokeResponse is the envelope returned by a plugin's Invoke method. Error
+// carries a plugin-reported failure across process boundaries; in-process
+// plugins may return a Go error instead.
+type InvokeResponse struct {
+    Output json.RawMessage `json:"output,omitempty"`
+    Error  string          `json:"error,omitempty"`
+}
+
+// NewInvokeResponse marshals output into a response envelope.
+func NewInvokeResponse(output any) (InvokeResponse, error) {
+    data, err := json.Marshal(output)
+    if err != nil {
+        return InvokeResponse{}, fmt.Errorf("failed to encode output: %w", err)
+    }
+    return InvokeResponse{Output: data}, nil
+}
+
+// Decode unmarshals the response output into v.
+func (r InvokeResponse) Decode(v any) error {
+    if len(r.Output) == 0 {
+        return nil
+    }
+    return json.Unmarshal(r.Output, v)
+}
+
+// Invoker is implemented by plugins that accept structured input and return
+// a result.
+type Invoker interface {
+    Invoke(ctx context.Context, req InvokeRequest) (InvokeResponse, error)
+}
+
+func (m *Manager) Invoke(name string, input any) (*InvokeResponse, error) {
+    return m.InvokeContext(context.Background(), name, input)
+}
+
+func (m *Manager) InvokeContext(ctx context.Context, name string, input any) (*InvokeResponse, error) {
+    return m.invoke(ctx, name, "", input)
+}
+
+func (m *Manager) invoke(ctx context.Context, name, operation string, input any) (*InvokeResponse, error) {
+    req := InvokeRequest{Plugin: name, Operation: operation}
+    if input != nil {
+        data, err := json.Marshal(input)
+        if err != nil {
+            return nil, &PluginError{Op: "invoke", Plugin: name, Err: fmt.Errorf("failed to encode input: %w", err)}
+        }
+        req.Input = data
+    }
+
+    var resp InvokeResponse
+    err := m.runPlugin(ctx, name, "invoke", operation, func(ctx context.Context, p Plugin) error {
+        invoker, ok := p.(Invoker)
+        if !ok {
+            return ErrInvokeNotSupported
+        }
+
+        var err error
+        resp, err = invoker.Invoke(ctx, req)
+        if err != nil {
+            return err
+        }
+        if resp.Error != "" {
+            return errors.New(resp.Error)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return &resp, nil
+}
@@ -0,0 +1,276 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "slices"
+    "strings"
+)
+
+// LoadPolicy decides when a plugin is opened and initialized.
+type LoadPolicy string
+
+const (
+    // LoadEager plugins are activated as soon as they are loaded.
+    LoadEager LoadPolicy = "eager"
+    // LoadLazy plugins are only registered when they are loaded, and are
+    // opened, verified and initialized when they are first executed or
+    // needed as a dependency.
+    LoadLazy LoadPolicy = "lazy"
+)
+
+func (p LoadPolicy) valid() bool {
+    return p == LoadEager || p == LoadLazy
+}
+
+// activationKey marks contexts of lazy activations in progress. Its value is
+// an activationChain.
+type activationKey struct{}
+
+// activationChain is the chain of entries a caller is activating, outermost
+// first, each waiting for the activation of the next.
+type activationChain struct {
+    caller  *activation
+    entries []*pluginEntry
+}
+
+// activation stands for one caller activating lazy plugins. Each activation
+// locks the entries of its chain one after the other, so activations of
+// plugins that depend on each other can wait for each other's entries.
+type activation struct {
+    // waiting is the entry the activation waits to lock. It is guarded by
+    // m.activeMu.
+    waiting *pluginEntry
+}
+
+// SetLoadPolicy sets the load policy of a plugin in the config. It applies
+// the next time the plugin is loaded.
+func (m *Manager) SetLoadPolicy(name string, policy LoadPolicy) error {
+    if !policy.valid() {
+        return fmt.Errorf("unknown load policy %q", policy)
+    }
+    name = m.canonicalName(name)
+    if err := m.config.SetLoadPolicy(name, policy); err != nil {
+        return err
+    }
+    return m.config.Save()
+}
+
+// policyFor returns the load policy of a plugin: its policy in the config,
+// looked up by name and by name without extension, or else the manager's
+// default.
+func (m *Manager) policyFor(name string) LoadPolicy {
+    if policy, ok := m.config.LoadPolicy(name); ok {
+        return policy
+    }
+    if policy, ok := m.config.LoadPolicy(strings.TrimSuffix(name, filepath.Ext(name))); ok {
+        return policy
+    }
+    if m.defaultPolicy != "" {
+        return m.defaultPolicy
+    }
+    return LoadEager
+}
+
+// awaitingActivation returns the registered entry for name if it is a lazy
+// plugin that has not been activated yet, or is being activated. exists
+// reports whether name refers to a registered plugin at all.
+func (m *Manager) awaitingActivation(name string) (entry *pluginEntry, awaiting, exists bool) {
+    m.mu.RLock()
+    entry, exists = m.lookupLocked(name)
+    m.mu.RUnlock()
+
+    if !exists || !entry.lazy {
+        return nil, false, exists
+    }
+    switch state, _ := entry.currentState(); state {
+    case StateDiscovered, StateVerified, StateLoaded, StateInitialized:
+        return entry, true, true
+    }
+    return nil, false, true
+}
+
+// identifyLazy opens the lazy plugins without a manifest that have not been
+// opened yet, without initializing them, so that they are registered under
+// the names they report. Plugins another operation is working on are
+// skipped. It reports whether any plugin was opened.
+func (m *Manager) identifyLazy(ctx context.Context) bool {
+    m.mu.RLock()
+    var unidentified []*pluginEntry
+    for _, entry := range m.plugins {
+        if state, _ := entry.currentState(); entry.lazy && entry.manifest == nil && state == StateDiscovered {
+            unidentified = append(unidentified, entry)
+        }
+    }
+    m.mu.RUnlock()
+
+    identified := false
+    for _, entry := range unidentified {
+        if !entry.mu.TryLock() {
+            continue
+        }
+        m.mu.RLock()
+        registered := m.plugins[entry.key] == entry
+        m.mu.RUnlock()
+        if state, _ := entry.currentState(); registered && state == StateDiscovered {
+            if err := m.openPlugin(ctx, entry); err == nil {
+                identified = true
+            }
+        }
+        entry.mu.Unlock()
+    }
+    return identified
+}
+
+// activateLazy opens and initializes a lazy plugin that is awaiting
+// activation, activating the lazy plugins it depends on first. Other plugins
+// are left alone. It returns the key the plugin is registered under
+// afterwards, which changes if the plugin reports a name or version other
+// than the one it was registered under. Activations hold the lifecycle lock
+// of the plugin they activate, so only activations of the same plugin wait
+// for each other.
+func (m *Manager) activateLazy(ctx context.Context, name string) (string, error) {
+    chain, nested := ctx.Value(activationKey{}).(activationChain)
+    pending, awaiting, exists := m.awaitingActivation(name)
+    // name may be the name a lazy plugin without a manifest reports.
+    if !exists && m.identifyLazy(ctx) {
+        pending, awaiting, _ = m.awaitingActivation(name)
+    }
+    if !awaiting {
+        return name, nil
+    }
+    if !nested {
+        chain.caller = &activation{}
+    }
+    // A plugin already in the chain, or locked by another caller waiting
+    // for a plugin in the chain, depends on itself.
+    if slices.Contains(chain.entries, pending) || !m.awaitEntry(chain.caller, pending) {
+        names := make([]string, 0, len(chain.entries)+1)
+        for _, entry := range chain.entries {
+            names = append(names, entry.key)
+        }
+        return name, fmt.Errorf("%w: %s", ErrCircularDependency, strings.Join(append(names, name), " -> "))
+    }
+
+    entry, err := m.lockEntry(name)
+    m.holdEntry(chain.caller, entry)
+    if err != nil {
+        return name, nil
+    }
+    defer entry.mu.Unlock()
+    defer m.holdEntry(nil, entry)
+
+    // Another caller may have activated, failed or unloaded the plugin
+    // meanwhile. A plugin without a manifest may already have been opened
+    // to learn its name.
+    state, _ := entry.currentState()
+    if !entry.lazy || state != StateDiscovered && state != StateLoaded {
+        return entry.key, nil
+    }
+
+    chain.entries = append(slices.Clip(chain.entries), entry)
+    ctx = context.WithValue(ctx, activationKey{}, chain)
+
+    if entry.manifest != nil {
+        for _, dep := range entry.manifest.dependencyNames() {
+            if _, err := m.activateLazy(ctx, m.dependencyTarget(dep, entry.manifest.Dependencies[dep])); err != nil {
+                return name, m.failPlugin(entry, fmt.Errorf("failed to activate dependency %s of %s: %w", dep, name, err))
+            }
+        }
+        if unplanned, _ := m.planLoad([]*pluginEntry{entry}); unplanned[entry.key] != nil {
+            return name, m.failPlugin(entry, fmt.Errorf("dependency check failed for %s: %w", name, unplanned[entry.key]))
+        }
+    }
+
+    if state == StateDiscovered {
+        if err := m.openPlugin(ctx, entry); err != nil {
+            return name, err
+        }
+    }
+    return entry.key, m.activatePlugin(ctx, entry)
+}
+
+// awaitEntry records that caller is about to wait for the lifecycle lock of
+// entry. It reports false instead if the activation holding that lock waits,
+// directly or through other activations, for an entry caller holds, as
+// waiting would then deadlock.
+func (m *Manager) awaitEntry(caller *activation, entry *pluginEntry) bool {
+    m.activeMu.Lock()
+    defer m.activeMu.Unlock()
+
+    for next := entry; next != nil; {
+        holder := m.activeBy[next]
+        if holder == nil {
+            break
+        }
+        if holder == caller {
+            return false
+        }
+        next = holder.waiting
+    }
+    caller.waiting = entry
+    return true
+}
+
+// holdEntry records that caller holds the lifecycle lock of entry and no
+// longer waits, or with a nil caller, that the lock was released.
+func (m *Manager) holdEntry(caller *activation, entry *pluginEntry) {
+    m.activeMu.Lock()
+    defer m.activeMu.Unlock()
+
+    if caller != nil {
+        caller.waiting = nil
+    }
+    switch {
+    case entry == nil:
+    case caller == nil:
+        delete(m.activeBy, entry)
+    default:
+        m.activeBy[entry] = caller
+    }
+}
+
+// activateDependencies activates the lazy plugins an opened plugin depends
+// on, so that they can be used as its dependencies.
+func (m *Manager) activateDependencies(ctx context.Context, entry *pluginEntry) error {
+    for _, dep := range sortedKeys(entry.metadata.Dependencies) {
+        if _, err := m.activateLazy(ctx, m.dependencyTarget(dep, entry.metadata.Dependencies[dep])); err != nil {
+            return fmt.Errorf("failed to activate dependency %s: %w", dep, err)
+        }
+    }
+    return nil
+}
+
+// dependencyTarget returns the key of the version a dependency on dep with
+// constraint will resolve to: the highest version that satisfies it and is
+// either loaded or a lazy plugin awaiting activation, whose version is taken
+// from its manifest. If there is none, dep is returned unchanged.
+func (m *Manager) dependencyTarget(dep, constraint string) string {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    candidates := m.dependencyCandidatesLocked(dep)
+    for i := len(candidates) - 1; i >= 0; i-- {
+        candidate := candidates[i]
+        version := candidate.version
+        switch state, _ := candidate.currentState(); state {
+        case StateRunning, StateDisabled:
+            version = candidate.metadata.Version
+        case StateDiscovered, StateVerified, StateLoaded, StateInitialized:
+            if !candidate.lazy {
+                continue
+            }
+        default:
+            continue
+        }
+        if compatible, _ := isVersionCompatible(version, constraint); version == "" || compatible {
+            return candidate.key
+        }
+    }
+    return dep
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestLazyPluginsWithoutManifest(t *testing.T) {
+    m := newTestManager(t, WithLoadPolicy(LoadLazy))
+    dependency := &testPlugin{name: "LB"}
+    dependent := &testPlugin{name: "LA", deps: map[string]string{"LB": "^1.0.0"}}
+    other := &testPlugin{name: "LC"}
+    paths := []string{registerTestPlugin(dependent), registerTestPlugin(dependency), registerTestPlugin(other)}
+
+    if err := m.LoadPlugins(paths); err != nil {
+        t.Fatalf("LoadPlugins: %v", err)
+    }
+    for _, path := range paths {
+        if state, err := m.State(nameFromPath(path)); err != nil || state != StateDiscovered {
+            t.Fatalf("%s is %s (%v), want discovered", path, state, err)
+        }
+    }
+
+    // Until it is opened, a plugin without a manifest is known by the name
+    // it is registered under.
+    if err := m.ExecutePlugin(nameFromPath(paths[2])); err != nil {
+        t.Fatalf("ExecutePlugin by registration name: %v", err)
+    }
+    if state, err := m.State("LC"); err != nil || state != StateRunning {
+        t.Fatalf("LC is %s (%v), want running", state, err)
+    }
+
+    // The name it reports is learned by opening it when nothing else
+    // answers to that name, and so is that of its dependency.
+    if err := m.ExecutePlugin("LA"); err != nil {
+        t.Fatalf("ExecutePlugin by metadata name: %v", err)
+    }
+    for _, name := range []string{"LA", "LB"} {
+        if state, err := m.State(name); err != nil || state != StateRunning {
+            t.Fatalf("%s is %s (%v), want running", name, state, err)
+        }
+    }
+}
+
+// newLazyTestManager returns a manager that loads every plugin lazily with
+// loader.
+func newLazyTestManager(t *testing.T, loader Loader) *Manager {
+    return newTestManager(t,
+        WithLoadPolicy(LoadLazy),
+        WithLoader(loader),
+        WithVerifier(nopVerifier{}),
+    )
+}
+
+// gatedLoader is a fileLoader that holds back opening the file called gated
+// until release is closed, and closes opening when it starts to.
+type gatedLoader struct {
+    fileLoader
+    gated   string
+    opening chan struct{}
+    release chan struct{}
+}
+
+func (l gatedLoader) Load(path string) (Plugin, error) {
+    if filepath.Base(path) == l.gated {
+        close(l.opening)
+        <-l.release
+    }
+    return l.fileLoader.Load(path)
+}
+
+func TestLazyActivationDoesNotWaitForOtherPlugins(t *testing.T) {
+    dir := t.TempDir()
+    initStarted, release := make(chan struct{}), make(chan struct{})
+    slow := &testPlugin{name: "LazySlow", init: func() error {
+        close(initStarted)
+        <-release
+        return nil
+    }}
+    fast := &testPlugin{name: "LazyFast"}
+    m := newLazyTestManager(t, fileLoader{plugins: map[string]*testPlugin{"slow.test": slow, "fast.test": fast}})
+    for name, p := range map[string]*testPlugin{"slow.test": slow, "fast.test": fast} {
+        if err := m.LoadPlugin(writeTestPlugin(t, dir, name, p)); err != nil {
+            t.Fatalf("LoadPlugin %s: %v", name, err)
+        }
+    }
+
+    slowDone := make(chan error, 1)
+    go func() { slowDone <- m.ExecutePlugin("LazySlow") }()
+    <-initStarted
+
+    fastDone := make(chan error, 1)
+    go func() { fastDone <- m.ExecutePlugin("LazyFast") }()
+    select {
+    case err := <-fastDone:
+        if err != nil {
+            t.Fatalf("ExecutePlugin LazyFast: %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("activating LazyFast waited for LazySlow's Init")
+    }
+
+    close(release)
+    if err := <-slowDone; err != nil {
+        t.Fatalf("ExecutePlugin LazySlow: %v", err)
+    }
+}
+
+func TestLazyActivationsWaitingForEachOtherFail(t *testing.T) {
+    dir := t.TempDir()
+    // x names y by its file name, as y has no manifest. y only reports that
+    // it depends on x once it is opened.
+    x := &testPlugin{name: "CycleX", deps: map[string]string{"y": "*"}}
+    y := &testPlugin{name: "CycleY", deps: map[string]string{"CycleX": "*"}}
+    loader := gatedLoader{
+        fileLoader: fileLoader{plugins: map[string]*testPlugin{"x.test": x, "y.test": y}},
+        gated:      "y.test",
+        opening:    make(chan struct{}),
+        release:    make(chan struct{}),
+    }
+    m := newLazyTestManager(t, loader)
+    for _, path := range []string{writeTestPlugin(t, dir, "x.test", x), writeTestPlugin(t, dir, "y.test", nil)} {
+        if err := m.LoadPlugin(path); err != nil {
+            t.Fatalf("LoadPlugin %s: %v", path, err)
+        }
+    }
+
+    // y's activation holds y while it is opened; x's then holds x and
+    // waits for y. Once opened, y's activation needs x.
+    yDone := make(chan error, 1)
+    go func() { yDone <- m.ExecutePlugin("y") }()
+    <-loader.opening
+    xDone := make(chan error, 1)
+    go func() { xDone <- m.ExecutePlugin("CycleX") }()
+    deadline := time.Now().Add(5 * time.Second)
+    for !waitingFor(m, "y") {
+        if time.Now().After(deadline) {
+            t.Fatal("activation of CycleX never waited for y")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    close(loader.release)
+
+    var circular bool
+    for _, done := range []chan error{yDone, xDone} {
+        select {
+        case err := <-done:
+            if err == nil {
+                t.Fatal("ExecutePlugin of a plugin in a dependency cycle succeeded")
+            }
+            circular = circular || errors.Is(err, ErrCircularDependency)
+        case <-time.After(5 * time.Second):
+            t.Fatal("activations waiting for each other deadlocked")
+        }
+    }
+    if !circular {
+        t.Fatal("neither activation returned ErrCircularDependency")
+    }
+}
+
+// waitingFor reports whether an activation waits to lock the plugin
+// registered under name.
+func waitingFor(m *Manager, name string) bool {
+    m.mu.RLock()
+    entry, _ := m.lookupLocked(name)
+    m.mu.RUnlock()
+
+    m.activeMu.Lock()
+    defer m.activeMu.Unlock()
+    for _, caller := range m.activeBy {
+        if entry != nil && caller.waiting == entry {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "fmt"
+    "path/filepath"
+    "plugin"
+)
+
+// Loader is a plugin backend. The manager opens each plugin with the first
+// loader whose Match accepts the plugin's path.
+type Loader interface {
+    Match(path string) bool
+    Load(path string) (Plugin, error)
+}
+
+// SymbolLoader is implemented by loaders whose plugins export a Go value,
+// which may implement an older generation of the Plugin interface.
+// LoadSymbol returns the value without asserting its type, so that an
+// APIAdapter can wrap it, together with the host API constraint the plugin
+// exports as HostAPISymbol, if any.
+type SymbolLoader interface {
+    Loader
+    LoadSymbol(path string) (symbol any, hostAPI string, err error)
+}
+
+// GoPluginLoader loads plugins built with -buildmode=plugin through the
+// standard library plugin package. It matches files ending in ".so".
+type GoPluginLoader struct{}
+
+func (GoPluginLoader) Match(path string) bool {
+    return filepath.Ext(path) == ".so"
+}
+
+// Load checks the plugin's build against the host with
+// CheckBuildCompatibility before opening it.
+func (l GoPluginLoader) Load(path string) (Plugin, error) {
+    name := filepath.Base(path)
+
+    symPlugin, _, err := l.LoadSymbol(path)
+    if err != nil {
+        return nil, err
+    }
+
+    loaded, ok := symPlugin.(Plugin)
+    if !ok {
+        return nil, &PluginError{Op: "assert", Plugin: name, Err: ErrInvalidPluginInterface}
+    }
+
+    return loaded, nil
+}
+
+// LoadSymbol checks the plugin's build against the host, opens it and looks
+// up its PluginSymbol and, if it exports one, its HostAPISymbol.
+func (GoPluginLoader) LoadSymbol(path string) (any, string, error) {
+    name := filepath.Base(path)
+
+    if err := CheckBuildCompatibility(path); err != nil {
+        return nil, "", err
+    }
+
+    p, err := plugin.Open(path)
+    if err != nil {
+        return nil, "", &PluginError{Op: "open", Plugin: name, Err: err}
+    }
+
+    symPlugin, err := p.Lookup(PluginSymbol)
+    if err != nil {
+        return nil, "", &PluginError{Op: "lookup", Plugin: name, Err: err}
+    }
+
+    var hostAPI string
+    if symHostAPI, err := p.Lookup(HostAPISymbol); err == nil {
+        constraint, ok := symHostAPI.(*string)
+        if !ok {
+            return nil, "", &PluginError{Op: "lookup", Plugin: name, Err: fmt.Errorf("%s must be a string variable", HostAPISymbol)}
+        }
+        hostAPI = *constraint
+    }
+    return symPlugin, hostAPI, nil
+}
+
+// loaderFor returns the first loader that matches path.
+func (m *Manager) loaderFor(path string) (Loader, error) {
+    for _, loader := range m.loaders {
+        if loader.Match(path) {
+            return loader, nil
+        }
+    }
+    return nil, &PluginError{Op: "open", Plugin: filepath.Base(path), Err: fmt.Errorf("%w: %s", ErrNoLoader, path)}
+}
@@ -3,10 +3,12 @@
 package pluginmanager
 
 import (
+    "context"
+    "errors"
     "fmt"
+    "io"
     "path/filepath"
-    "plugin"
-    "strconv"
+    "runtime/debug"
     "strings"
     "sync"
     "time"
@@ -15,347 +17,1323 @@ import (
 )
 
 type Manager struct {
-    plugins       map[string]*lazyPlugin
+    plugins       map[string]*pluginEntry
+    versions      map[string][]*pluginEntry
+    aliases       map[string]string
+    defaults      map[string]string
     config        *Config
     dependencies  map[string][]string
     stats         map[string]*PluginStats
+    opStats       map[string]map[string]*PluginStats
     eventBus      *EventBus
     sandbox       Sandbox
     logger        *zap.Logger
-    publicKeyPath string
+    verifier      Verifier
+    loaders       []Loader
+    clock         Clock
     mu            sync.RWMutex
+    closed        bool
+    inflight      sync.WaitGroup
+    statsMu       sync.Mutex
+
+    loadConcurrency int
+    defaultPolicy   LoadPolicy
+    activeBy        map[*pluginEntry]*activation
+    activeMu        sync.Mutex
+
+    defaultTimeout time.Duration
+    timeouts       map[string]time.Duration
+    timeoutMu      sync.RWMutex
+
+    panicThreshold int
+    panics         map[string]int
+    panicMu        sync.Mutex
+
+    hostAPIVersion string
+    hostAPIRange   string
+    apiAdapters    []APIAdapter
+    hostAPI        *hostAPI
 }
 
-type lazyPlugin struct {
-    path   string
-    loaded Plugin
+// pluginEntry is one plugin instance known to the manager together with its
+// lifecycle state. The registry holds one entry per plugin version, under
+// its key; during a hot-reload the incoming version has an entry of its own
+// until it is swapped in.
+//
+// Lifecycle operations on an entry hold its mu for their whole duration and
+// take the registry lock m.mu only around registry reads and writes, so
+// plugins are loaded, reloaded and unloaded independently of each other.
+// Locks are always taken in the order entry.mu, m.mu.
+type pluginEntry struct {
+    key      string
+    name     string
+    version  string
+    semver   *Version
+    path     string
+    loaded   Plugin
+    metadata PluginMetadata
+    manifest *Manifest
+    lazy     bool
+    deps     []string
+    inflight inflightTracker
+    mu       sync.Mutex
+
+    state       PluginState
+    cause       error
+    initialized bool
+    stateMu     sync.Mutex
 }
 
-func (lp *lazyPlugin) load() error {
-    if lp.loaded == nil {
-        p, err := plugin.Open(lp.path)
+// NewManager creates a manager that keeps its config at configPath and
+// verifies plugins against the RSA public key at publicKeyPath. Options
+// replace the default components.
+func NewManager(configPath, pluginDir, publicKeyPath string, opts ...Option) (*Manager, error) {
+    m := &Manager{
+        plugins:        make(map[string]*pluginEntry),
+        versions:       make(map[string][]*pluginEntry),
+        aliases:        make(map[string]string),
+        defaults:       make(map[string]string),
+        dependencies:   make(map[string][]string),
+        stats:          make(map[string]*PluginStats),
+        opStats:        make(map[string]map[string]*PluginStats),
+        timeouts:       make(map[string]time.Duration),
+        panics:         make(map[string]int),
+        activeBy:       make(map[*pluginEntry]*activation),
+        hostAPIVersion: HostAPIVersion,
+    }
+
+    for _, opt := range opts {
+        opt(m)
+    }
+
+    api, err := newHostAPI(m.hostAPIVersion, m.hostAPIRange, m.apiAdapters)
+    if err != nil {
+        return nil, fmt.Errorf("invalid host API: %w", err)
+    }
+    m.hostAPI = api
+
+    if m.config == nil {
+        config, err := LoadConfig(configPath)
         if err != nil {
-            return fmt.Errorf("failed to open plugin: %w", err)
+            return nil, fmt.Errorf("failed to load config: %w", err)
         }
+        m.config = config
+    }
 
-        symPlugin, err := p.Lookup(PluginSymbol)
+    if m.logger == nil {
+        logger, err := zap.NewProduction()
         if err != nil {
-            return fmt.Errorf("failed to lookup plugin symbol: %w", err)
+            return nil, fmt.Errorf("failed to create logger: %w", err)
         }
+        m.logger = logger
+    }
+
+    if m.eventBus == nil {
+        m.eventBus = NewEventBus()
+    }
+    // A bus passed in with WithEventBus keeps its own panic handler, if any.
+    m.eventBus.setDefaultPanicHandler(func(event Event, recovered any, stack []byte) {
+        m.logger.Error("Event handler panicked",
+            zap.String("event", event.Name()),
+            zap.Any("panic", recovered),
+            zap.ByteString("stack", stack))
+    })
+
+    if m.sandbox == nil {
+        m.sandbox = NewLinuxSandbox(filepath.Join(pluginDir, "sandbox"))
+    }
+    if m.verifier == nil {
+        m.verifier = &RSAVerifier{PublicKeyPath: publicKeyPath}
+    }
+    m.loaders = append(m.loaders,
+        StaticLoader{},
+        GoPluginLoader{},
+        &RPCLoader{MaxRestarts: defaultRPCRestarts},
+        &ExecLoader{Timeout: defaultExecTimeout, Logger: m.logger},
+    )
+    if m.clock == nil {
+        m.clock = systemClock{}
+    }
+
+    return m, nil
+}
+
+func (m *Manager) LoadPlugin(path string) error {
+    return m.LoadPluginContext(context.Background(), path)
+}
+
+func (m *Manager) LoadPluginContext(ctx context.Context, path string) error {
+    path, manifest, err := resolvePlugin(path)
+    if err != nil {
+        return fmt.Errorf("failed to read manifest: %w", err)
+    }
+
+    m.mu.Lock()
+    if m.closed {
+        m.mu.Unlock()
+        return ErrManagerClosed
+    }
+    entry, err := m.registerPlugin(expectedKey(path, manifest), path, manifest)
+    m.mu.Unlock()
+    if err != nil {
+        return err
+    }
+    defer entry.mu.Unlock()
+
+    if entry.lazy {
+        return nil
+    }
+
+    // A plugin whose manifest names a missing dependency is not opened at
+    // all.
+    if failed, _ := m.planLoad([]*pluginEntry{entry}); failed[entry.key] != nil {
+        return m.failPlugin(entry, fmt.Errorf("dependency check failed for %s: %w", entry.key, failed[entry.key]))
+    }
+
+    if err := m.openPlugin(ctx, entry); err != nil {
+        return err
+    }
+
+    return m.activatePlugin(ctx, entry)
+}
+
+// registerPlugin adds a discovered plugin to the registry under key and
+// returns its entry with the entry's lifecycle lock held. A plugin that
+// previously failed is replaced, unless another operation is working on it;
+// any other existing plugin is refused. The caller must hold m.mu.
+func (m *Manager) registerPlugin(key, path string, manifest *Manifest) (*pluginEntry, error) {
+    if err := m.claimKey(key, path); err != nil {
+        return nil, err
+    }
+
+    name, version := splitPluginKey(key)
+    policy := m.policyFor(name)
+    entry := &pluginEntry{key: key, name: name, version: version, path: path, manifest: manifest, lazy: policy == LoadLazy, state: StateDiscovered}
+    if err := m.registerAliases(entry); err != nil {
+        return nil, err
+    }
+    entry.mu.Lock()
+    m.putEntryLocked(entry)
+
+    m.eventBus.Publish(PluginRegisteredEvent{PluginName: name, Version: version, Path: path, Policy: policy})
+    return entry, nil
+}
+
+// lockEntry returns the registered entry that name refers to with its
+// lifecycle lock held. The caller must unlock entry.mu.
+func (m *Manager) lockEntry(name string) (*pluginEntry, error) {
+    for {
+        m.mu.RLock()
+        entry, exists := m.lookupLocked(name)
+        m.mu.RUnlock()
 
-        plugin, ok := symPlugin.(Plugin)
-        if !ok {
-            return fmt.Errorf("invalid plugin interface")
+        if !exists {
+            return nil, ErrPluginNotFound
         }
 
-        lp.loaded = plugin
+        entry.mu.Lock()
+
+        // The entry may have been replaced or removed while we waited.
+        m.mu.RLock()
+        current, _ := m.lookupLocked(name)
+        m.mu.RUnlock()
+
+        if current == entry {
+            return entry, nil
+        }
+        entry.mu.Unlock()
     }
-    return nil
 }
 
-func NewManager(configPath, pluginDir, publicKeyPath string) (*Manager, error) {
-    config, err := LoadConfig(configPath)
+// openPlugin verifies the plugin file of entry, opens it and reads its
+// metadata, without running any of its lifecycle hooks.
+func (m *Manager) openPlugin(ctx context.Context, entry *pluginEntry) error {
+    pluginName := entry.key
+
+    loader, err := m.loaderFor(entry.path)
+    if err != nil {
+        return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w", pluginName, err))
+    }
+
+    if trusted, ok := loader.(TrustedLoader); !ok || !trusted.Trusted(entry.path) {
+        if err := m.verifier.Verify(entry.path); err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to verify plugin signature: %w", err))
+        }
+    }
+    if entry.manifest != nil {
+        if err := entry.manifest.verifyFile(entry.path); err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to verify plugin %s: %w", pluginName, err))
+        }
+        // A manifest lets an incompatible plugin be refused before it is
+        // opened.
+        if _, _, err := m.hostAPI.negotiate(pluginName, entry.manifest.HostAPI); err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w", pluginName, err))
+        }
+    }
+    if err := m.setState(entry, StateVerified, nil); err != nil {
+        return err
+    }
+
+    loaded, adapted, err := m.openInstance(ctx, pluginName, loader, entry.path, entry.manifest)
     if err != nil {
-        return nil, fmt.Errorf("failed to load config: %w", err)
+        return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w", pluginName, err))
+    }
+    entry.loaded = loaded
+
+    metadata, err := m.readMetadata(ctx, pluginName, loaded)
+    if err != nil {
+        return m.failPlugin(entry, fmt.Errorf("failed to read metadata of %s: %w", pluginName, err))
+    }
+    entry.metadata = metadata
+
+    if _, err := ParseVersion(entry.metadata.Version); err != nil {
+        return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w: %w", pluginName, ErrIncompatibleVersion, err))
+    }
+    for dep, constraint := range entry.metadata.Dependencies {
+        if _, err := ParseConstraint(constraint); err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: dependency %s: %w", pluginName, dep, err))
+        }
+    }
+    if entry.manifest != nil {
+        if err := entry.manifest.verifyMetadata(entry.metadata); err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w", pluginName, err))
+        }
     }
 
-    logger, _ := zap.NewProduction()
+    if !adapted {
+        loaded, err := m.hostAPI.adapt(pluginName, entry.loaded, entry.metadata)
+        if err != nil {
+            return m.failPlugin(entry, fmt.Errorf("failed to load plugin %s: %w", pluginName, err))
+        }
+        entry.loaded = loaded
+    }
 
-    sandboxDir := filepath.Join(pluginDir, "sandbox")
+    // From here on the plugin is known by the name and version it reports.
+    if err := m.renameEntry(entry, entry.metadata.Name, entry.metadata.Version); err != nil {
+        return m.failPlugin(entry, fmt.Errorf("failed to register plugin %s: %w", pluginName, err))
+    }
 
-    return &Manager{
-        plugins:       make(map[string]*lazyPlugin),
-        config:        config,
-        dependencies:  make(map[string][]string),
-        stats:         make(map[string]*PluginStats),
-        eventBus:      NewEventBus(),
-        sandbox:       NewLinuxSandbox(sandboxDir),
-        logger:        logger,
-        publicKeyPath: publicKeyPath,
-    }, nil
+    return m.setState(entry, StateLoaded, nil)
 }
 
-func (m *Manager) LoadPlugin(path string) error {
+// openInstance opens the plugin at path with loader. If the caller stops
+// waiting before the loader returns, the instance it returns later is closed
+// instead, so that no plugin process outlives the failed open. adapted
+// reports whether the instance was already wrapped in an APIAdapter.
+func (m *Manager) openInstance(ctx context.Context, name string, loader Loader, path string, manifest *Manifest) (Plugin, bool, error) {
+    var (
+        mu        sync.Mutex
+        loaded    Plugin
+        adapted   bool
+        abandoned bool
+    )
+    err := m.callPlugin(ctx, name, "open", func(context.Context) error {
+        instance, wrapped, err := m.loadInstance(name, loader, path, manifest)
+
+        mu.Lock()
+        defer mu.Unlock()
+        if abandoned {
+            m.closeInstance(name, instance)
+        } else {
+            loaded, adapted = instance, wrapped
+        }
+        return err
+    })
+
+    mu.Lock()
+    defer mu.Unlock()
+    if err != nil {
+        abandoned = true
+        m.closeInstance(name, loaded)
+        return nil, false, err
+    }
+    return loaded, adapted, nil
+}
+
+// loadInstance loads the plugin at path with loader. When the manager has
+// adapters and loader is a SymbolLoader, the plugin's exported value is
+// adapted before it is required to implement Plugin.
+func (m *Manager) loadInstance(name string, loader Loader, path string, manifest *Manifest) (Plugin, bool, error) {
+    symbolLoader, ok := loader.(SymbolLoader)
+    if !ok || len(m.hostAPI.adapters) == 0 {
+        loaded, err := loader.Load(path)
+        return loaded, false, err
+    }
+
+    symbol, required, err := symbolLoader.LoadSymbol(path)
+    if err != nil {
+        return nil, false, err
+    }
+    if manifest != nil && manifest.HostAPI != "" {
+        required = manifest.HostAPI
+    }
+    return m.hostAPI.adaptSymbol(name, symbol, required)
+}
+
+// readMetadata asks an opened plugin for its metadata.
+func (m *Manager) readMetadata(ctx context.Context, name string, plugin Plugin) (PluginMetadata, error) {
+    results := make(chan PluginMetadata, 1)
+    err := m.callPlugin(ctx, name, "metadata", func(context.Context) error {
+        results <- plugin.Metadata()
+        return nil
+    })
+    if err != nil {
+        return PluginMetadata{}, err
+    }
+    return <-results, nil
+}
+
+// initPluginEntry checks an opened plugin's dependencies and runs its PreLoad
+// and Init hooks. The dependencies are recorded before the hooks run, so
+// that they cannot be unloaded underneath the plugin. The caller must hold
+// entry.mu.
+func (m *Manager) initPluginEntry(ctx context.Context, entry *pluginEntry) error {
+    pluginName := entry.key
+    plugin := entry.loaded
+
+    if err := entry.requireState("initialize", StateLoaded, StateDisabled); err != nil {
+        return err
+    }
+
+    if err := m.activateDependencies(ctx, entry); err != nil {
+        return m.failPlugin(entry, fmt.Errorf("dependency check failed for %s: %w", pluginName, err))
+    }
+    if err := m.reserveDependencies(entry); err != nil {
+        return m.failPlugin(entry, fmt.Errorf("dependency check failed for %s: %w", pluginName, err))
+    }
+
+    if err := m.callPlugin(ctx, pluginName, "preload", func(context.Context) error {
+        return plugin.PreLoad()
+    }); err != nil {
+        m.releaseDependencies(entry)
+        return m.failPlugin(entry, fmt.Errorf("pre-load hook failed for %s: %w", pluginName, err))
+    }
+
+    if err := m.callPlugin(ctx, pluginName, "init", func(ctx context.Context) error {
+        return initPlugin(ctx, plugin)
+    }); err != nil {
+        m.releaseDependencies(entry)
+        return m.failPlugin(entry, fmt.Errorf("initialization failed for %s: %w", pluginName, err))
+    }
+    entry.stateMu.Lock()
+    entry.initialized = true
+    entry.stateMu.Unlock()
+
+    return m.setState(entry, StateInitialized, nil)
+}
+
+// reserveDependencies resolves an entry's dependencies to the loaded
+// versions that best satisfy them and records their keys for the entry.
+// Recording happens under the same registry lock as the check, so an unload
+// of a dependency either sees the new dependent or runs before the check.
+func (m *Manager) reserveDependencies(entry *pluginEntry) error {
     m.mu.Lock()
     defer m.mu.Unlock()
 
-    pluginName := filepath.Base(path)
-    if _, exists := m.plugins[pluginName]; exists {
-        return fmt.Errorf("plugin %s already loaded", pluginName)
+    deps := make([]string, 0, len(entry.metadata.Dependencies))
+    for dep, constraint := range entry.metadata.Dependencies {
+        depKey, err := m.checkDependency(dep, constraint)
+        if err != nil {
+            return err
+        }
+        deps = append(deps, depKey)
     }
 
-    if err := m.VerifyPluginSignature(path, m.publicKeyPath); err != nil {
-        return fmt.Errorf("failed to verify plugin signature: %w", err)
+    entry.deps = deps
+    if m.plugins[entry.key] == entry {
+        m.dependencies[entry.key] = deps
     }
+    return nil
+}
 
-    lazyPlug := &lazyPlugin{path: path}
-    if err := lazyPlug.load(); err != nil {
-        return fmt.Errorf("failed to load plugin %s: %w", pluginName, err)
+// releaseDependencies forgets the dependencies recorded for an entry that did
+// not finish loading.
+func (m *Manager) releaseDependencies(entry *pluginEntry) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.plugins[entry.key] == entry {
+        delete(m.dependencies, entry.key)
     }
+}
 
-    plugin := lazyPlug.loaded
+// activatePlugin initializes an opened plugin, runs its PostLoad hook and
+// makes it available for execution. A plugin disabled in the config is
+// moved to StateDisabled instead, and initialized when it is enabled. The
+// caller must hold entry.mu.
+func (m *Manager) activatePlugin(ctx context.Context, entry *pluginEntry) error {
+    pluginName := entry.key
 
-    if err := plugin.PreLoad(); err != nil {
-        return fmt.Errorf("pre-load hook failed for %s: %w", pluginName, err)
+    if state, _ := entry.currentState(); state == StateLoaded && m.config.IsDisabled(entry.name) {
+        if err := m.setState(entry, StateDisabled, nil); err != nil {
+            return err
+        }
+        m.logger.Info("Plugin disabled in the config, not initializing it", zap.String("plugin", pluginName))
+        return nil
     }
 
-    if err := plugin.Init(); err != nil {
-        return fmt.Errorf("initialization failed for %s: %w", pluginName, err)
+    if err := m.initPluginEntry(ctx, entry); err != nil {
+        return err
     }
 
-    if err := plugin.PostLoad(); err != nil {
-        return fmt.Errorf("post-load hook failed for %s: %w", pluginName, err)
+    if err := m.callPlugin(ctx, pluginName, "postload", func(context.Context) error {
+        return entry.loaded.PostLoad()
+    }); err != nil {
+        m.releaseDependencies(entry)
+        return m.failPlugin(entry, fmt.Errorf("post-load hook failed for %s: %w", pluginName, err))
     }
 
-    m.plugins[pluginName] = lazyPlug
+    m.statsMu.Lock()
     m.stats[pluginName] = &PluginStats{}
+    m.statsMu.Unlock()
 
-    metadata := plugin.Metadata()
-    m.dependencies[pluginName] = make([]string, 0, len(metadata.Dependencies))
-    for dep, constraint := range metadata.Dependencies {
-        m.dependencies[pluginName] = append(m.dependencies[pluginName], dep)
-        if err := m.checkDependency(dep, constraint); err != nil {
-            delete(m.plugins, pluginName)
-            delete(m.stats, pluginName)
-            delete(m.dependencies, pluginName)
-            return fmt.Errorf("dependency check failed for %s: %w", pluginName, err)
-        }
+    if err := m.setState(entry, StateRunning, nil); err != nil {
+        return err
     }
 
-    m.eventBus.Publish(PluginLoadedEvent{PluginName: pluginName})
-    m.logger.Info("Plugin loaded", zap.String("plugin", pluginName))
+    m.eventBus.Publish(PluginLoadedEvent{PluginName: entry.name, Version: entry.version})
+    m.eventBus.Publish(PluginActivatedEvent{PluginName: entry.name, Version: entry.version, Lazy: entry.lazy})
+    m.logger.Info("Plugin loaded", zap.String("plugin", pluginName), zap.Bool("lazy", entry.lazy))
 
     return nil
 }
 
 func (m *Manager) UnloadPlugin(name string) error {
-    m.mu.Lock()
-    defer m.mu.Unlock()
+    return m.UnloadPluginContext(context.Background(), name)
+}
 
-    plugin, exists := m.plugins[name]
-    if !exists {
-        return ErrPluginNotFound
+// UnloadPluginContext unloads a plugin version; an unqualified name unloads
+// the default version. It refuses with ErrPluginHasDependents
+// while other loaded plugins depend on it; use UnloadPluginCascade to unload
+// the dependents as well.
+func (m *Manager) UnloadPluginContext(ctx context.Context, name string) error {
+    entry, err := m.lockEntry(name)
+    if err != nil {
+        return err
     }
+    defer entry.mu.Unlock()
+
+    return m.unloadEntry(ctx, entry, true)
+}
 
-    if err := plugin.loaded.PreUnload(); err != nil {
-        return fmt.Errorf("pre-unload hook failed for %s: %w", name, err)
+// unloadEntry runs the unload hooks of a plugin and removes it. Hooks are
+// skipped for plugins that never got past Init. When checkDependents is set,
+// plugins that other loaded plugins depend on are refused. The caller must
+// hold entry.mu.
+func (m *Manager) unloadEntry(ctx context.Context, entry *pluginEntry, checkDependents bool) error {
+    name := entry.key
+
+    m.mu.Lock()
+    if checkDependents {
+        if dependents := m.dependentsOf(name); len(dependents) > 0 {
+            m.mu.Unlock()
+            return fmt.Errorf("%w: %s is required by %s", ErrPluginHasDependents, name, strings.Join(dependents, ", "))
+        }
+    }
+    previous, _ := entry.currentState()
+    err := m.setState(entry, StateUnloading, nil)
+    m.mu.Unlock()
+    if err != nil {
+        return err
     }
 
-    if err := plugin.loaded.Shutdown(); err != nil {
-        return fmt.Errorf("shutdown failed for %s: %w", name, err)
+    if entry.initialized {
+        if err := m.drainPlugin(ctx, name, entry); err != nil {
+            m.setState(entry, previous, nil)
+            entry.inflight.resume()
+            return fmt.Errorf("failed to drain executions of %s: %w", name, err)
+        }
+
+        if err := m.callPlugin(ctx, name, "preunload", func(context.Context) error {
+            return entry.loaded.PreUnload()
+        }); err != nil {
+            entry.inflight.resume()
+            return m.failPlugin(entry, fmt.Errorf("pre-unload hook failed for %s: %w", name, err))
+        }
+
+        if err := m.callPlugin(ctx, name, "shutdown", func(ctx context.Context) error {
+            return shutdownPlugin(ctx, entry.loaded)
+        }); err != nil {
+            entry.inflight.resume()
+            return m.failPlugin(entry, fmt.Errorf("shutdown failed for %s: %w", name, err))
+        }
     }
+    m.closePlugin(entry)
 
-    delete(m.plugins, name)
-    delete(m.dependencies, name)
-    delete(m.stats, name)
+    m.mu.Lock()
+    m.removePlugin(name)
+    m.mu.Unlock()
 
-    m.eventBus.Publish(PluginUnloadedEvent{PluginName: name})
+    m.eventBus.Publish(PluginUnloadedEvent{PluginName: entry.name, Version: entry.version})
     m.logger.Info("Plugin unloaded", zap.String("plugin", name))
 
     return nil
 }
 
+// removePlugin drops every record of a plugin version. The caller must hold
+// m.mu.
+func (m *Manager) removePlugin(key string) {
+    if entry, exists := m.plugins[key]; exists {
+        entry.inflight.retire()
+        if state, _ := entry.currentState(); state == StateUnloading {
+            m.setState(entry, StateUnloaded, nil)
+        }
+        m.unregisterAliases(entry)
+    }
+    m.deleteEntryLocked(key)
+    delete(m.dependencies, key)
+    m.resetPanics(key)
+
+    m.statsMu.Lock()
+    delete(m.stats, key)
+    delete(m.opStats, key)
+    m.statsMu.Unlock()
+}
+
+// closePlugin releases the resources of a plugin instance that implements
+// io.Closer, such as the process of a subprocess plugin.
+func (m *Manager) closePlugin(entry *pluginEntry) {
+    m.closeInstance(entry.key, entry.loaded)
+}
+
+func (m *Manager) closeInstance(name string, plugin Plugin) {
+    closer, ok := plugin.(io.Closer)
+    if !ok {
+        return
+    }
+    if err := closer.Close(); err != nil {
+        m.logger.Warn("Failed to close plugin", zap.String("plugin", name), zap.Error(err))
+    }
+}
+
 func (m *Manager) ExecutePlugin(name string) error {
-    m.mu.RLock()
-    plugin, exists := m.plugins[name]
-    stats := m.stats[name]
-    m.mu.RUnlock()
+    return m.ExecutePluginContext(context.Background(), name)
+}
 
-    if !exists {
-        return ErrPluginNotFound
+func (m *Manager) ExecutePluginContext(ctx context.Context, name string) error {
+    return m.runPlugin(ctx, name, "execute", "", executePlugin)
+}
+
+// runPlugin performs a single sandboxed, timed call into a loaded plugin and
+// records it in the plugin's stats, and in the operation's stats when
+// operation is set. It backs ExecutePlugin, Invoke and CallOperation.
+func (m *Manager) runPlugin(ctx context.Context, name, op, operation string, fn func(context.Context, Plugin) error) error {
+    name, err := m.activateLazy(ctx, name)
+    if err != nil {
+        return err
+    }
+
+    plugin, err := m.acquirePlugin(ctx, name, op)
+    if err != nil {
+        return err
+    }
+    // The execution counts as in flight until the plugin returns, even if
+    // the caller stops waiting for it first.
+    release := func() {
+        plugin.inflight.release()
+        m.inflight.Done()
     }
 
+    if err := plugin.requireState(op, StateRunning); err != nil {
+        release()
+        return err
+    }
+    name = plugin.key
+
     if err := m.sandbox.Enable(); err != nil {
+        release()
         return fmt.Errorf("failed to enable sandbox for %s: %w", name, err)
     }
     defer m.sandbox.Disable()
 
-    if err := plugin.load(); err != nil {
-        return fmt.Errorf("failed to load plugin %s: %w", name, err)
+    start := m.clock.Now()
+    err = m.callPluginReleasing(ctx, name, op, func(ctx context.Context) error {
+        return fn(ctx, plugin.loaded)
+    }, release)
+    executionTime := m.clock.Since(start)
+
+    m.recordExecution(name, operation, executionTime)
+
+    if errors.Is(err, ErrPluginPanicked) && m.reachedPanicThreshold(name) {
+        m.logger.Warn("Plugin quarantined", zap.String("plugin", name))
+        m.failPlugin(plugin, ErrPluginQuarantined)
     }
 
-    start := time.Now()
-    err := plugin.loaded.Execute()
-    executionTime := time.Since(start)
+    if err != nil {
+        return fmt.Errorf("execution failed for %s: %w", name, err)
+    }
+
+    m.logger.Info("Plugin executed", zap.String("plugin", name), zap.String("op", op), zap.Duration("duration", executionTime))
+    return nil
+}
+
+// acquirePlugin looks up a plugin and registers an execution against both the
+// manager and the plugin instance. While the instance is being drained for an
+// unload or hot-reload the execution waits, and is then routed to whatever
+// version is registered under name afterwards. On success the caller must
+// release the instance and call m.inflight.Done.
+func (m *Manager) acquirePlugin(ctx context.Context, name, op string) (*pluginEntry, error) {
+    for {
+        m.mu.RLock()
+        if m.closed {
+            m.mu.RUnlock()
+            return nil, ErrManagerClosed
+        }
+        plugin, exists := m.lookupLocked(name)
+        if exists {
+            m.inflight.Add(1)
+        }
+        m.mu.RUnlock()
+
+        if !exists {
+            return nil, ErrPluginNotFound
+        }
+
+        err := plugin.inflight.acquire(ctx)
+        if err == nil {
+            return plugin, nil
+        }
+
+        m.inflight.Done()
+        if err != errInstanceRetired {
+            return nil, &PluginError{Op: op, Plugin: name, Err: err}
+        }
+    }
+}
+
+// recordExecution adds one execution to the stats of a plugin and, when
+// operation is set, of that operation.
+func (m *Manager) recordExecution(name, operation string, executionTime time.Duration) {
+    m.statsMu.Lock()
+    defer m.statsMu.Unlock()
+
+    stats, exists := m.stats[name]
+    if !exists {
+        return
+    }
 
-    m.mu.Lock()
     stats.ExecutionCount++
     stats.LastExecutionTime = executionTime
     stats.TotalExecutionTime += executionTime
-    m.mu.Unlock()
 
-    if err != nil {
-        return fmt.Errorf("execution failed for %s: %w", name, err)
+    if operation != "" {
+        opStats := m.operationStats(name, operation)
+        opStats.ExecutionCount++
+        opStats.LastExecutionTime = executionTime
+        opStats.TotalExecutionTime += executionTime
+    }
+}
+
+// drainPlugin waits, bounded by ctx and the plugin's timeout, for the
+// executions running against an instance to finish. New executions are held
+// back until the instance is resumed or retired.
+func (m *Manager) drainPlugin(ctx context.Context, name string, plugin *pluginEntry) error {
+    if timeout := m.timeoutFor(name); timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
     }
 
-    m.logger.Info("Plugin executed", zap.String("plugin", name), zap.Duration("duration", executionTime))
+    if err := plugin.inflight.drain(ctx); err != nil {
+        if errors.Is(err, context.DeadlineExceeded) {
+            err = ErrPluginTimeout
+        }
+        return &PluginError{Op: "drain", Plugin: name, Err: err}
+    }
     return nil
 }
 
 func (m *Manager) HotReload(name string, path string) error {
-    m.mu.Lock()
-    defer m.mu.Unlock()
+    return m.HotReloadContext(context.Background(), name, path)
+}
 
-    oldPlugin, ok := m.plugins[name]
-    if !ok {
-        return ErrPluginNotFound
+// HotReloadContext replaces a loaded plugin version with the plugin at path.
+// The new version runs its full load lifecycle and, when both versions
+// implement StatefulPlugin, receives the old version's state before it is
+// swapped in. It takes over the old version's dependents, whose constraints
+// it must satisfy or the reload fails with ErrIncompatibleVersion, and, if
+// its version differs, is registered under its own key. The reload is
+// transactional: if any step fails, the old version stays active and
+// untouched and a PluginHotReloadFailed event is published.
+func (m *Manager) HotReloadContext(ctx context.Context, name string, path string) error {
+    oldPlugin, err := m.lockEntry(name)
+    if err != nil {
+        return err
+    }
+    defer oldPlugin.mu.Unlock()
+    key, name := oldPlugin.key, oldPlugin.name
+
+    m.mu.RLock()
+    closed := m.closed
+    m.mu.RUnlock()
+    if closed {
+        return ErrManagerClosed
+    }
+
+    if err := oldPlugin.requireState("be hot-reloaded", StateRunning, StateDisabled, StateFailed); err != nil {
+        return err
+    }
+
+    path, manifest, err := resolvePlugin(path)
+    if err != nil {
+        return fmt.Errorf("failed to read manifest: %w", err)
+    }
+
+    newEntry := &pluginEntry{key: key, name: name, version: oldPlugin.version, path: path, manifest: manifest, state: StateDiscovered}
+    fail := func(err error) error {
+        m.closePlugin(newEntry)
+        m.eventBus.Publish(PluginHotReloadFailedEvent{PluginName: name, Err: err})
+        m.logger.Warn("Plugin hot-reload failed, keeping old version", zap.String("plugin", key), zap.Error(err))
+        return err
+    }
+
+    if err := m.openPlugin(ctx, newEntry); err != nil {
+        return fail(fmt.Errorf("failed to load new version of %s: %w", key, err))
+    }
+    if reported := newEntry.metadata.Name; reported != "" && reported != name {
+        return fail(m.failPlugin(newEntry, fmt.Errorf("new version of %s reports the name %s", key, reported)))
     }
 
-    if err := m.VerifyPluginSignature(path, m.publicKeyPath); err != nil {
-        return fmt.Errorf("failed to verify new plugin signature: %w", err)
+    newKey := pluginKey(name, newEntry.metadata.Version)
+    m.mu.RLock()
+    _, taken := m.plugins[newKey]
+    err = m.checkDependentsLocked(oldPlugin, newEntry.metadata.Version)
+    m.mu.RUnlock()
+    if taken && newKey != key {
+        return fail(m.failPlugin(newEntry, fmt.Errorf("%w: %s", ErrPluginAlreadyLoaded, newKey)))
+    }
+    if err != nil {
+        return fail(m.failPlugin(newEntry, fmt.Errorf("new version of %s: %w", key, err)))
     }
+    newEntry.stateMu.Lock()
+    newEntry.key, newEntry.version = newKey, newEntry.metadata.Version
+    newEntry.stateMu.Unlock()
 
-    newLazyPlugin := &lazyPlugin{path: path}
-    if err := newLazyPlugin.load(); err != nil {
-        return fmt.Errorf("failed to load new version of %s: %w", name, err)
+    if err := m.initPluginEntry(ctx, newEntry); err != nil {
+        return fail(fmt.Errorf("failed to initialize new version of %s: %w", key, err))
     }
 
-    newPlugin := newLazyPlugin.loaded
+    newPlugin := newEntry.loaded
 
-    metadata := newPlugin.Metadata()
-    for dep, constraint := range metadata.Dependencies {
-        if err := m.checkDependency(dep, constraint); err != nil {
-            return fmt.Errorf("dependency check failed for new version of %s: %w", name, err)
+    // From here on the new version is initialized and must be shut down again
+    // if the reload does not go through.
+    abort := func(err error) error {
+        if err := m.callPlugin(ctx, newKey, "shutdown", func(ctx context.Context) error {
+            return shutdownPlugin(ctx, newPlugin)
+        }); err != nil {
+            m.logger.Warn("Shutdown failed for rejected new version", zap.String("plugin", newKey), zap.Error(err))
         }
+        return fail(m.failPlugin(newEntry, err))
+    }
+
+    // Hold new executions back until the new version is in place, so they
+    // are routed to it, and let the running ones finish before the old
+    // version's state is exported.
+    if err := m.drainPlugin(ctx, key, oldPlugin); err != nil {
+        return abort(fmt.Errorf("failed to drain executions of %s: %w", key, err))
+    }
+
+    if err := m.migrateState(ctx, key, oldPlugin, newEntry); err != nil {
+        oldPlugin.inflight.resume()
+        return abort(fmt.Errorf("state migration failed for %s: %w", key, err))
+    }
+
+    if err := m.callPlugin(ctx, newKey, "postload", func(context.Context) error {
+        return newPlugin.PostLoad()
+    }); err != nil {
+        oldPlugin.inflight.resume()
+        return abort(fmt.Errorf("post-load hook failed for new version of %s: %w", key, err))
     }
 
-    if err := newPlugin.Init(); err != nil {
-        return fmt.Errorf("initialization failed for new version of %s: %w", name, err)
+    oldState, _ := oldPlugin.currentState()
+    m.setState(newEntry, StateRunning, nil)
+    if oldState == StateDisabled {
+        m.setState(newEntry, StateDisabled, nil)
     }
 
-    if err := oldPlugin.loaded.PreUnload(); err != nil {
-        m.logger.Warn("Pre-unload hook failed for old version", zap.String("plugin", name), zap.Error(err))
+    m.mu.Lock()
+    // Dependents may have been added while the new version was initialized.
+    if err := m.checkDependentsLocked(oldPlugin, newEntry.version); err != nil {
+        m.mu.Unlock()
+        oldPlugin.inflight.resume()
+        return abort(fmt.Errorf("new version of %s: %w", key, err))
+    }
+    if newKey != key {
+        if err := m.claimKey(newKey, path); err != nil {
+            m.mu.Unlock()
+            oldPlugin.inflight.resume()
+            return abort(err)
+        }
+    }
+    m.unregisterAliases(oldPlugin)
+    m.deleteEntryLocked(key)
+    delete(m.dependencies, key)
+    m.putEntryLocked(newEntry)
+    m.dependencies[newKey] = newEntry.deps
+    if newKey != key {
+        m.replaceKeyLocked(oldPlugin, newEntry)
     }
-    if err := oldPlugin.loaded.Shutdown(); err != nil {
-        m.logger.Warn("Shutdown failed for old version", zap.String("plugin", name), zap.Error(err))
+    if err := m.registerAliases(newEntry); err != nil {
+        m.logger.Warn("Ignoring aliases of new version", zap.String("plugin", newKey), zap.Error(err))
     }
+    m.mu.Unlock()
 
-    m.plugins[name] = newLazyPlugin
-    m.dependencies[name] = make([]string, 0, len(metadata.Dependencies))
-    for dep := range metadata.Dependencies {
-        m.dependencies[name] = append(m.dependencies[name], dep)
+    oldPlugin.inflight.retire()
+    m.resetPanics(key)
+    m.resetPanics(newKey)
+
+    m.setState(oldPlugin, StateUnloading, nil)
+    if oldPlugin.initialized {
+        if err := m.callPlugin(ctx, key, "preunload", func(context.Context) error {
+            return oldPlugin.loaded.PreUnload()
+        }); err != nil {
+            m.logger.Warn("Pre-unload hook failed for old version", zap.String("plugin", key), zap.Error(err))
+        }
+        if err := m.callPlugin(ctx, key, "shutdown", func(ctx context.Context) error {
+            return shutdownPlugin(ctx, oldPlugin.loaded)
+        }); err != nil {
+            m.logger.Warn("Shutdown failed for old version", zap.String("plugin", key), zap.Error(err))
+        }
     }
+    m.closePlugin(oldPlugin)
+    m.setState(oldPlugin, StateUnloaded, nil)
 
-    m.eventBus.Publish(PluginHotReloadedEvent{PluginName: name})
-    m.logger.Info("Plugin hot-reloaded", zap.String("plugin", name))
+    m.eventBus.Publish(PluginHotReloadedEvent{PluginName: name, Version: newEntry.version})
+    m.logger.Info("Plugin hot-reloaded", zap.String("plugin", newKey))
 
     return nil
 }
 
-func (m *Manager) checkDependency(depName, constraint string) error {
-    depPlugin, exists := m.plugins[depName]
-    if !exists {
-        return fmt.Errorf("missing dependency: %s", depName)
+// migrateState hands the old version's state to the new version when both
+// implement StatefulPlugin.
+func (m *Manager) migrateState(ctx context.Context, name string, oldPlugin, newPlugin *pluginEntry) error {
+    exporter, ok := oldPlugin.loaded.(StatefulPlugin)
+    if !ok {
+        return nil
+    }
+    importer, ok := newPlugin.loaded.(StatefulPlugin)
+    if !ok {
+        m.logger.Warn("New version does not accept state, discarding old state", zap.String("plugin", name))
+        return nil
     }
 
-    if err := depPlugin.load(); err != nil {
-        return fmt.Errorf("failed to load dependency %s: %w", depName, err)
+    var state []byte
+    if err := m.callPlugin(ctx, name, "exportstate", func(ctx context.Context) error {
+        var err error
+        state, err = exporter.ExportState(ctx)
+        return err
+    }); err != nil {
+        return err
     }
 
-    depVersion := depPlugin.loaded.Metadata().Version
-    if !isVersionCompatible(depVersion, constraint) {
-        return fmt.Errorf("incompatible version for dependency %s: required %s, got %s", depName, constraint, depVersion)
+    return m.callPlugin(ctx, name, "importstate", func(ctx context.Context) error {
+        return importer.ImportState(ctx, oldPlugin.metadata.Version, state)
+    })
+}
+
+// SetDefaultTimeout bounds every plugin hook and execution that has no
+// plugin-specific timeout. A zero duration disables the default.
+func (m *Manager) SetDefaultTimeout(timeout time.Duration) {
+    m.timeoutMu.Lock()
+    defer m.timeoutMu.Unlock()
+    m.defaultTimeout = timeout
+}
+
+// SetPluginTimeout overrides the default timeout for a single plugin, or for
+// one version of it when name is qualified as name@version. A zero duration
+// removes the override.
+func (m *Manager) SetPluginTimeout(name string, timeout time.Duration) {
+    name, version := splitPluginKey(name)
+    name = pluginKey(m.canonicalName(name), version)
+
+    m.timeoutMu.Lock()
+    defer m.timeoutMu.Unlock()
+    if timeout <= 0 {
+        delete(m.timeouts, name)
+        return
     }
+    m.timeouts[name] = timeout
+}
 
-    return nil
+func (m *Manager) timeoutFor(key string) time.Duration {
+    m.timeoutMu.RLock()
+    defer m.timeoutMu.RUnlock()
+    if timeout, ok := m.timeouts[key]; ok {
+        return timeout
+    }
+    if name, _ := splitPluginKey(key); name != key {
+        if timeout, ok := m.timeouts[name]; ok {
+            return timeout
+        }
+    }
+    return m.defaultTimeout
+}
+
+// callPlugin runs fn on its own goroutine so that the caller is released as
+// soon as ctx is cancelled or the plugin's timeout expires, even if the plugin
+// itself ignores the context.
+func (m *Manager) callPlugin(ctx context.Context, name, op string, fn func(context.Context) error) error {
+    return m.callPluginReleasing(ctx, name, op, fn, nil)
 }
 
-func isVersionCompatible(currentVersion, constraint string) bool {
-    parts := strings.Split(constraint, " ")
-    if len(parts) != 2 {
-        return false
+// callPluginReleasing is callPlugin for calls that hold something for as
+// long as the plugin runs. release, if set, is called once fn returns, which
+// may be after the caller was released, or at once if fn is never called.
+func (m *Manager) callPluginReleasing(ctx context.Context, name, op string, fn func(context.Context) error, release func()) error {
+    if release == nil {
+        release = func() {}
+    }
+    if err := ctx.Err(); err != nil {
+        release()
+        return &PluginError{Op: op, Plugin: name, Err: err}
     }
 
-    operator := parts[0]
-    requiredVersion := parts[1]
+    if timeout := m.timeoutFor(name); timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
 
-    switch operator {
-    case ">=":
-        return compareVersions(currentVersion, requiredVersion) >= 0
-    case ">":
-        return compareVersions(currentVersion, requiredVersion) > 0
-    case "<=":
-        return compareVersions(currentVersion, requiredVersion) <= 0
-    case "<":
-        return compareVersions(currentVersion, requiredVersion) < 0
-    case "==":
-        return compareVersions(currentVersion, requiredVersion) == 0
-    default:
-        return false
+    done := make(chan error, 1)
+    go func() {
+        defer release()
+        defer func() {
+            if r := recover(); r != nil {
+                done <- m.recoverPlugin(name, op, r, debug.Stack())
+            }
+        }()
+        done <- fn(ctx)
+    }()
+
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+            return &PluginError{Op: op, Plugin: name, Err: ErrPluginTimeout}
+        }
+        return &PluginError{Op: op, Plugin: name, Err: ctx.Err()}
     }
 }
 
-func compareVersions(v1, v2 string) int {
-    parts1 := strings.Split(v1, ".")
-    parts2 := strings.Split(v2, ".")
+// SetPanicThreshold quarantines a plugin once it has panicked n times since it
+// was loaded or last hot-reloaded. A quarantined plugin refuses executions
+// with ErrPluginQuarantined. Zero disables quarantine.
+func (m *Manager) SetPanicThreshold(n int) {
+    m.panicMu.Lock()
+    defer m.panicMu.Unlock()
+    m.panicThreshold = n
+}
 
-    for i := 0; i < len(parts1) && i < len(parts2); i++ {
-        n1, _ := strconv.Atoi(parts1[i])
-        n2, _ := strconv.Atoi(parts2[i])
+// recoverPlugin converts a panic raised by a plugin into a PluginError and
+// reports it.
+func (m *Manager) recoverPlugin(name, op string, recovered any, stack []byte) error {
+    m.panicMu.Lock()
+    m.panics[name]++
+    m.panicMu.Unlock()
+
+    m.logger.Error("Plugin panicked",
+        zap.String("plugin", name),
+        zap.String("op", op),
+        zap.Any("panic", recovered),
+        zap.ByteString("stack", stack))
+    pluginName, version := splitPluginKey(name)
+    m.eventBus.Publish(PluginPanickedEvent{PluginName: pluginName, Version: version, Op: op, Value: recovered, Stack: stack})
+
+    return &PluginError{Op: op, Plugin: name, Err: &PanicError{Value: recovered, Stack: stack}}
+}
 
-        if n1 < n2 {
-            return -1
-        } else if n1 > n2 {
-            return 1
-        }
+func (m *Manager) reachedPanicThreshold(name string) bool {
+    m.panicMu.Lock()
+    defer m.panicMu.Unlock()
+    return m.panicThreshold > 0 && m.panics[name] >= m.panicThreshold
+}
+
+func (m *Manager) resetPanics(name string) {
+    m.panicMu.Lock()
+    defer m.panicMu.Unlock()
+    delete(m.panics, name)
+}
+
+// checkDependency resolves a dependency, given by name, alias or
+// name@version, to the highest loaded version that satisfies constraint, and
+// returns its key. The caller must hold m.mu.
+func (m *Manager) checkDependency(depName, constraint string) (string, error) {
+    candidates := m.dependencyCandidatesLocked(depName)
+    if len(candidates) == 0 {
+        return "", fmt.Errorf("%w: %s", ErrMissingDependency, depName)
     }
 
-    if len(parts1) < len(parts2) {
-        return -1
-    } else if len(parts1) > len(parts2) {
-        return 1
+    var stateErr error
+    var versions []string
+    for i := len(candidates) - 1; i >= 0; i-- {
+        depPlugin := candidates[i]
+        err := depPlugin.requireState("be used as a dependency", StateRunning, StateDisabled)
+        if err == nil && !depPlugin.isInitialized() {
+            // It was disabled in the config when it was loaded.
+            err = &StateError{Plugin: depPlugin.key, State: StateDisabled, Op: "be used as a dependency before it is enabled"}
+        }
+        if err != nil {
+            if stateErr == nil {
+                stateErr = err
+            }
+            continue
+        }
+
+        depVersion := depPlugin.metadata.Version
+        compatible, err := isVersionCompatible(depVersion, constraint)
+        if err != nil {
+            return "", fmt.Errorf("dependency %s: %w", depName, err)
+        }
+        if compatible {
+            return depPlugin.key, nil
+        }
+        versions = append(versions, depVersion)
     }
 
-    return 0
+    if len(versions) == 0 {
+        return "", fmt.Errorf("%w: %s: %w", ErrMissingDependency, depName, stateErr)
+    }
+    return "", fmt.Errorf("%w for dependency %s: required %s, got %s", ErrIncompatibleVersion, depName, constraint, strings.Join(versions, ", "))
 }
 
+// EnablePlugin enables a plugin in the config. Loaded versions of it that
+// were disabled start accepting executions again; those that were disabled
+// when they were loaded are initialized first.
 func (m *Manager) EnablePlugin(name string) error {
+    return m.EnablePluginContext(context.Background(), name)
+}
+
+func (m *Manager) EnablePluginContext(ctx context.Context, name string) error {
+    name = m.canonicalName(name)
     if err := m.config.EnablePlugin(name); err != nil {
         return err
     }
+    if err := m.recordPaths(name); err != nil {
+        return err
+    }
+    if err := m.enableVersions(ctx, name); err != nil {
+        return err
+    }
     return m.config.Save()
 }
 
+// DisablePlugin disables a plugin in the config. Running versions of it stay
+// loaded but refuse executions until it is enabled again.
 func (m *Manager) DisablePlugin(name string) error {
+    name = m.canonicalName(name)
     if err := m.config.DisablePlugin(name); err != nil {
         return err
     }
+    if err := m.recordPaths(name); err != nil {
+        return err
+    }
+    if err := m.setVersionsState(name, StateRunning, StateDisabled); err != nil {
+        return err
+    }
     return m.config.Save()
 }
 
+// enableVersions moves the disabled versions of a plugin back to
+// StateRunning, activating those that were never initialized.
+func (m *Manager) enableVersions(ctx context.Context, name string) error {
+    m.mu.RLock()
+    versions := m.versionsLocked(name)
+    m.mu.RUnlock()
+
+    var errs []error
+    for _, version := range versions {
+        entry, err := m.lockEntry(version.key)
+        if err != nil {
+            continue
+        }
+        if state, _ := entry.currentState(); state == StateDisabled {
+            if entry.initialized {
+                err = m.setState(entry, StateRunning, nil)
+            } else {
+                err = m.activatePlugin(ctx, entry)
+            }
+        }
+        entry.mu.Unlock()
+        errs = append(errs, err)
+    }
+    return errors.Join(errs...)
+}
+
+// setVersionsState moves the loaded versions of a plugin that are in state
+// from to state to.
+func (m *Manager) setVersionsState(name string, from, to PluginState) error {
+    m.mu.RLock()
+    versions := m.versionsLocked(name)
+    m.mu.RUnlock()
+
+    for _, version := range versions {
+        entry, err := m.lockEntry(version.key)
+        if err != nil {
+            continue
+        }
+        if state, _ := entry.currentState(); state == from {
+            err = m.setState(entry, to, nil)
+        }
+        entry.mu.Unlock()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// LoadEnabledPlugins loads the plugins enabled in the config. Each name is
+// looked up in the static registry, then among the names, keys and aliases
+// in the manifests in pluginDir, then among the file names of plugins in
+// pluginDir without a manifest, and then among the paths recorded in the
+// config when the plugin was enabled. Every version found for a name is
+// loaded.
 func (m *Manager) LoadEnabledPlugins(pluginDir string) error {
+    index, err := m.indexPlugins(pluginDir)
+    if err != nil {
+        return fmt.Errorf("failed to read plugin directory: %w", err)
+    }
+
     enabled := m.config.EnabledPlugins()
+    paths := make([]string, 0, len(enabled))
+    seen := make(map[string]bool)
+    var errs []error
     for _, name := range enabled {
-        path := filepath.Join(pluginDir, name+".so")
-        if err := m.LoadPlugin(path); err != nil {
-            return err
+        if isRegistered(name) {
+            paths = append(paths, StaticPrefix+name)
+            continue
+        }
+        found, err := locatePlugin(index, pluginDir, name)
+        if recorded := m.config.PluginPaths(name); err != nil && len(recorded) > 0 {
+            found, err = recorded, nil
+        }
+        if err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        for _, path := range found {
+            if !seen[path] {
+                seen[path] = true
+                paths = append(paths, path)
+            }
         }
     }
-    return nil
+    return errors.Join(append(errs, m.LoadPlugins(paths))...)
 }
 
+// ListPlugins returns the names of the loaded plugins, each once however
+// many versions of it are loaded. Plugins that failed or are awaiting lazy
+// activation are left out; State reports on those. PluginVersions lists the
+// versions.
 func (m *Manager) ListPlugins() []string {
     m.mu.RLock()
     defer m.mu.RUnlock()
 
     plugins := make([]string, 0, len(m.plugins))
-    for name := range m.plugins {
-        plugins = append(plugins, name)
+    seen := make(map[string]bool, len(m.plugins))
+    for _, entry := range m.plugins {
+        if state, _ := entry.currentState(); state != StateRunning && state != StateDisabled {
+            continue
+        }
+        if !seen[entry.name] {
+            seen[entry.name] = true
+            plugins = append(plugins, entry.name)
+        }
     }
     return plugins
 }
 
+// GetPluginStats returns a snapshot of the execution stats of a plugin
+// version; an unqualified name refers to the default version.
 func (m *Manager) GetPluginStats(name string) (*PluginStats, error) {
     m.mu.RLock()
-    defer m.mu.RUnlock()
+    entry, exists := m.lookupLocked(name)
+    m.mu.RUnlock()
+    if !exists {
+        return nil, ErrPluginNotFound
+    }
 
-    stats, ok := m.stats[name]
+    m.statsMu.Lock()
+    defer m.statsMu.Unlock()
+
+    stats, ok := m.stats[entry.key]
     if !ok {
         return nil, ErrPluginNotFound
     }
-    return stats, nil
+    snapshot := *stats
+    return &snapshot, nil
 }
 
 func (m *Manager) SubscribeToEvent(eventName string, handler EventHandler) {
     m.eventBus.Subscribe(eventName, handler)
 }
+
+// Shutdown tears the manager down. It stops accepting new loads and
+// executions, waits for in-flight executions to finish, unloads every plugin
+// with dependents before their dependencies, and flushes the logger and
+// config. Plugins that fail to stop are still removed; the returned error
+// joins the failure of each one.
+func (m *Manager) Shutdown(ctx context.Context) error {
+    m.mu.Lock()
+    m.closed = true
+    m.mu.Unlock()
+
+    drained := make(chan struct{})
+    go func() {
+        m.inflight.Wait()
+        close(drained)
+    }()
+
+    select {
+    case <-drained:
+    case <-ctx.Done():
+        return fmt.Errorf("failed to drain in-flight executions: %w", ctx.Err())
+    }
+
+    m.mu.RLock()
+    graph := make(map[string][]string, len(m.plugins))
+    for name := range m.plugins {
+        graph[name] = m.dependencies[name]
+    }
+    m.mu.RUnlock()
+
+    order, err := sortDependencies(graph)
+    if err != nil {
+        return err
+    }
+
+    var errs []error
+    for i := len(order) - 1; i >= 0; i-- {
+        name := order[i]
+        entry, err := m.lockEntry(name)
+        if err != nil {
+            continue
+        }
+        if err := m.unloadEntry(ctx, entry, false); err != nil {
+            m.logger.Warn("Plugin failed to stop", zap.String("plugin", name), zap.Error(err))
+            m.closePlugin(entry)
+            m.mu.Lock()
+            m.removePlugin(name)
+            m.mu.Unlock()
+            errs = append(errs, err)
+        }
+        entry.mu.Unlock()
+    }
+
+    if err := m.config.Save(); err != nil {
+        errs = append(errs, fmt.Errorf("failed to save config: %w", err))
+    }
+
+    m.logger.Info("Plugin manager shut down")
+    // Sync commonly fails on console outputs such as stderr, which are
+    // unbuffered anyway, so its error is not reported.
+    _ = m.logger.Sync()
+
+    return errors.Join(errs...)
+}
+
+// Close shuts the manager down without a deadline.
+func (m *Manager) Close() error {
+    return m.Shutdown(context.Background())
+}
\ No newline at end of file
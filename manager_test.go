@@ -0,0 +1,213 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+    "go.uber.org/zap/zaptest/observer"
+)
+
+// slowLoader opens "slow:" paths after a delay, with instances that record
+// whether they were closed.
+type slowLoader struct {
+    delay  time.Duration
+    opened chan *closingPlugin
+}
+
+type closingPlugin struct {
+    testPlugin
+    closed atomic.Bool
+}
+
+func (p *closingPlugin) Close() error {
+    p.closed.Store(true)
+    return nil
+}
+
+func (l slowLoader) Match(path string) bool {
+    return strings.HasPrefix(path, "slow:")
+}
+
+func (l slowLoader) Load(path string) (Plugin, error) {
+    time.Sleep(l.delay)
+    p := &closingPlugin{testPlugin: testPlugin{name: strings.TrimPrefix(path, "slow:")}}
+    l.opened <- p
+    return p, nil
+}
+
+func TestTimedOutOpenClosesLateInstance(t *testing.T) {
+    loader := slowLoader{delay: 100 * time.Millisecond, opened: make(chan *closingPlugin, 2)}
+    m := newTestManager(t, WithLoader(loader), WithVerifier(nopVerifier{}))
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := m.LoadPluginContext(ctx, "slow:Late"); !errors.Is(err, ErrPluginTimeout) {
+        t.Fatalf("LoadPluginContext returned %v, want ErrPluginTimeout", err)
+    }
+
+    late := <-loader.opened
+    deadline := time.Now().Add(5 * time.Second)
+    for !late.closed.Load() {
+        if time.Now().After(deadline) {
+            t.Fatal("instance opened after the timeout was not closed")
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    // Loading again replaces the failed entry.
+    if err := m.LoadPlugin("slow:Late"); err != nil {
+        t.Fatalf("LoadPlugin after a timed-out open: %v", err)
+    }
+    if (<-loader.opened).closed.Load() {
+        t.Fatal("loaded instance was closed")
+    }
+}
+
+// BenchmarkExecute measures executions of a plugin while nothing else
+// happens, as a baseline for BenchmarkExecuteDuringSlowLoad.
+func BenchmarkExecute(b *testing.B) {
+    m := newTestManager(b)
+    loadTestPlugin(b, m, &testPlugin{name: "BenchFast"})
+
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            if err := m.ExecutePlugin("BenchFast"); err != nil {
+                b.Error(err)
+                return
+            }
+        }
+    })
+}
+
+// BenchmarkExecuteAmongManyPlugins measures executions of a plugin while
+// many other plugins are loaded. Looking the plugin up should not get slower
+// with their number.
+func BenchmarkExecuteAmongManyPlugins(b *testing.B) {
+    m := newTestManager(b)
+    for i := 0; i < 200; i++ {
+        loadTestPlugin(b, m, &testPlugin{name: fmt.Sprintf("BenchOther%d", i)})
+    }
+    loadTestPlugin(b, m, &testPlugin{name: "BenchFast"})
+
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if err := m.ExecutePlugin("BenchFast"); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkExecuteDuringSlowLoad measures executions of a plugin while
+// another plugin is stuck in Init. Its throughput should match
+// BenchmarkExecute.
+func BenchmarkExecuteDuringSlowLoad(b *testing.B) {
+    m := newTestManager(b)
+    loadTestPlugin(b, m, &testPlugin{name: "BenchFast"})
+
+    initStarted, release := make(chan struct{}), make(chan struct{})
+    slow := &testPlugin{name: "BenchSlow", init: func() error {
+        close(initStarted)
+        <-release
+        return nil
+    }}
+    loaded := make(chan error, 1)
+    go func() { loaded <- m.LoadPlugin(registerTestPlugin(slow)) }()
+    <-initStarted
+
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            if err := m.ExecutePlugin("BenchFast"); err != nil {
+                b.Error(err)
+                return
+            }
+        }
+    })
+    b.StopTimer()
+
+    close(release)
+    if err := <-loaded; err != nil {
+        b.Fatalf("slow load: %v", err)
+    }
+}
+
+func TestEventHandlerPanicLoggedOnCustomBus(t *testing.T) {
+    core, logs := observer.New(zap.ErrorLevel)
+    bus := NewEventBus()
+    m := newTestManager(t, WithEventBus(bus), WithLogger(zap.New(core)))
+    bus.Subscribe("PluginLoaded", func(Event) { panic("handler failed") })
+
+    loadTestPlugin(t, m, &testPlugin{name: "CustomBus"})
+
+    deadline := time.Now().Add(5 * time.Second)
+    for logs.FilterMessage("Event handler panicked").Len() == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("panic in an event handler was not logged")
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestListPluginsLeavesOutFailedPlugins(t *testing.T) {
+    m := newTestManager(t)
+    cycle := []string{
+        registerTestPlugin(&testPlugin{name: "CA", deps: map[string]string{"CB": "*"}}),
+        registerTestPlugin(&testPlugin{name: "CB", deps: map[string]string{"CA": "*"}}),
+    }
+    if err := m.LoadPlugins(cycle); !errors.Is(err, ErrCircularDependency) {
+        t.Fatalf("LoadPlugins returned %v, want ErrCircularDependency", err)
+    }
+    loadTestPlugin(t, m, &testPlugin{name: "Listed"})
+
+    if plugins := m.ListPlugins(); len(plugins) != 1 || plugins[0] != "Listed" {
+        t.Fatalf("ListPlugins returned %v, want [Listed]", plugins)
+    }
+}
+
+func TestConfigDisabledPluginIsNotInitialized(t *testing.T) {
+    m := newTestManager(t)
+    if err := m.config.DisablePlugin("Off"); err != nil {
+        t.Fatalf("DisablePlugin: %v", err)
+    }
+    var inits atomic.Int32
+    p := &testPlugin{name: "Off", init: func() error {
+        inits.Add(1)
+        return nil
+    }}
+    loadTestPlugin(t, m, p)
+
+    if state, err := m.State("Off"); err != nil || state != StateDisabled {
+        t.Fatalf("Off is %s (%v), want disabled", state, err)
+    }
+    if err := m.ExecutePlugin("Off"); !errors.Is(err, ErrInvalidPluginState) {
+        t.Fatalf("ExecutePlugin returned %v, want ErrInvalidPluginState", err)
+    }
+    dependent := &testPlugin{name: "NeedsOff", deps: map[string]string{"Off": "*"}}
+    if err := m.LoadPlugin(registerTestPlugin(dependent)); !errors.Is(err, ErrMissingDependency) {
+        t.Fatalf("LoadPlugin of a dependent returned %v, want ErrMissingDependency", err)
+    }
+    if inits.Load() != 0 || p.executions.Load() != 0 {
+        t.Fatal("disabled plugin was initialized or executed")
+    }
+
+    if err := m.EnablePlugin("Off"); err != nil {
+        t.Fatalf("EnablePlugin: %v", err)
+    }
+    if inits.Load() != 1 {
+        t.Fatalf("Init ran %d times after enabling, want 1", inits.Load())
+    }
+    if err := m.ExecutePlugin("Off"); err != nil {
+        t.Fatalf("ExecutePlugin after enabling: %v", err)
+    }
+}
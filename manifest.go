@@ -0,0 +1,166 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "io/fs"
+    "maps"
+    "os"
+    "path/filepath"
+)
+
+// BundleManifest is the name of the manifest file of a plugin bundle, a
+// directory holding a plugin binary together with its manifest.
+const BundleManifest = "plugin.json"
+
+// Manifest describes a plugin without opening it. It is stored next to the
+// plugin binary as <binary>.json, or as plugin.json in a bundle directory,
+// in which case Binary names the plugin file inside the bundle. A Go plugin
+// cannot be closed once it has been opened, so the manager checks a
+// plugin's manifest before opening it and refuses loads that would fail.
+//
+// Hashes maps an algorithm ("sha256" or "sha512") to the hex digest of the
+// binary. Signature, when set, is used by RSAVerifier in place of a .sig
+// file. Aliases are further names the plugin can be addressed by.
+type Manifest struct {
+    Name         string            `json:"name"`
+    Aliases      []string          `json:"aliases,omitempty"`
+    Version      string            `json:"version"`
+    Dependencies map[string]string `json:"dependencies,omitempty"`
+    HostAPI      string            `json:"host_api,omitempty"`
+    Binary       string            `json:"binary,omitempty"`
+    Exec         bool              `json:"exec,omitempty"`
+    Hashes       map[string]string `json:"hashes,omitempty"`
+    Signature    []byte            `json:"signature,omitempty"`
+}
+
+var manifestHashes = map[string]func() hash.Hash{
+    "sha256": sha256.New,
+    "sha512": sha512.New,
+}
+
+// ReadManifest reads the manifest of the plugin at path, which is either a
+// plugin binary or a bundle directory. The returned error matches
+// fs.ErrNotExist when the plugin has no manifest.
+func ReadManifest(path string) (*Manifest, error) {
+    if info, err := os.Stat(path); err == nil && info.IsDir() {
+        return readManifestFile(filepath.Join(path, BundleManifest))
+    }
+
+    manifest, err := readManifestFile(path + ".json")
+    if err == nil || !errors.Is(err, fs.ErrNotExist) {
+        return manifest, err
+    }
+
+    // The binary may be part of a bundle.
+    bundle, bundleErr := readManifestFile(filepath.Join(filepath.Dir(path), BundleManifest))
+    if bundleErr == nil && bundle.Binary == filepath.Base(path) {
+        return bundle, nil
+    }
+    return nil, err
+}
+
+func readManifestFile(path string) (*Manifest, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var manifest Manifest
+    if err := json.Unmarshal(data, &manifest); err != nil {
+        return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+    }
+    return &manifest, nil
+}
+
+// resolvePlugin returns the plugin binary at path, which may be a bundle
+// directory, together with its manifest, or nil if it has none.
+func resolvePlugin(path string) (string, *Manifest, error) {
+    manifest, err := ReadManifest(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return path, nil, nil
+    }
+    if err != nil {
+        return path, nil, err
+    }
+
+    if info, err := os.Stat(path); err == nil && info.IsDir() {
+        binary, err := bundleBinary(path, manifest)
+        return binary, manifest, err
+    }
+    return path, manifest, nil
+}
+
+// isBundle reports whether dir is a plugin bundle.
+func isBundle(dir string) bool {
+    info, err := os.Stat(filepath.Join(dir, BundleManifest))
+    return err == nil && !info.IsDir()
+}
+
+// bundleBinary returns the path of the plugin binary of a bundle directory.
+func bundleBinary(dir string, manifest *Manifest) (string, error) {
+    if manifest.Binary == "" || filepath.Base(manifest.Binary) != manifest.Binary {
+        return "", fmt.Errorf("bundle %s: manifest must name a binary inside the bundle", dir)
+    }
+    return filepath.Join(dir, manifest.Binary), nil
+}
+
+// dependencyNames returns the names of the manifest's dependencies, sorted.
+func (mf *Manifest) dependencyNames() []string {
+    return sortedKeys(mf.Dependencies)
+}
+
+// verifyFile checks the plugin binary at path against the manifest's
+// hashes.
+func (mf *Manifest) verifyFile(path string) error {
+    for algorithm, want := range mf.Hashes {
+        newHash, ok := manifestHashes[algorithm]
+        if !ok {
+            return fmt.Errorf("%w: unsupported hash algorithm %q", ErrManifestMismatch, algorithm)
+        }
+
+        file, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        h := newHash()
+        _, err = io.Copy(h, file)
+        file.Close()
+        if err != nil {
+            return err
+        }
+
+        if got := hex.EncodeToString(h.Sum(nil)); got != want {
+            return fmt.Errorf("%w: %s hash of %s is %s, manifest has %s", ErrManifestMismatch, algorithm, filepath.Base(path), got, want)
+        }
+    }
+    return nil
+}
+
+// verifyMetadata checks that a plugin reports the identity and dependencies
+// its manifest promised.
+func (mf *Manifest) verifyMetadata(metadata PluginMetadata) error {
+    if metadata.Name != mf.Name {
+        return fmt.Errorf("%w: plugin reports name %q, manifest has %q", ErrManifestMismatch, metadata.Name, mf.Name)
+    }
+    if metadata.Version != mf.Version {
+        return fmt.Errorf("%w: plugin reports version %q, manifest has %q", ErrManifestMismatch, metadata.Version, mf.Version)
+    }
+    if !maps.Equal(metadata.Dependencies, mf.Dependencies) && len(metadata.Dependencies)+len(mf.Dependencies) > 0 {
+        return fmt.Errorf("%w: plugin reports dependencies %v, manifest has %v", ErrManifestMismatch, metadata.Dependencies, mf.Dependencies)
+    }
+    if metadata.HostAPI != mf.HostAPI {
+        return fmt.Errorf("%w: plugin reports host API %q, manifest has %q", ErrManifestMismatch, metadata.HostAPI, mf.HostAPI)
+    }
+    return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license 
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Operation describes a named entry point advertised by a plugin through
+// PluginMetadata.Operations. Input and Output are free-form descriptions of
+// the JSON shapes the operation accepts and returns.
+type Operation struct {
+    Name        string `json:"name"`
+    Description string `json:"description,omitempty"`
+    Input       string `json:"input,omitempty"`
+    Output      string `json:"output,omitempty"`
+}
+
+// OperationInfo is an operation together with the plugin that provides it.
+type OperationInfo struct {
+    Plugin string `json:"plugin"`
+    Operation
+}
+
+// QualifiedName returns the "plugin.operation" name used by CallOperation.
+func (o OperationInfo) QualifiedName() string {
+    return o.Plugin + "." + o.Name
+}
+
+// ListOperations returns the operations of the loaded plugins. Operations of
+// a plugin's default version are listed under its name, those of other
+// versions under name@version.
+func (m *Manager) ListOperations() []OperationInfo {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    var operations []OperationInfo
+    for name, plugin := range m.plugins {
+        if plugin.requireState("list operations", StateRunning, StateDisabled) != nil {
+            continue
+        }
+        if m.isDefaultVersionLocked(plugin) {
+            name = plugin.name
+        }
+        for _, op := range plugin.metadata.Operations {
+            operations = append(operations, OperationInfo{Plugin: name, Operation: op})
+        }
+    }
+
+    sort.Slice(operations, func(i, j int) bool {
+        return operations[i].QualifiedName() < operations[j].QualifiedName()
+    })
+    return operations
+}
+
+func (m *Manager) CallOperation(qualifiedName string, input any) (*InvokeResponse, error) {
+    return m.CallOperationContext(context.Background(), qualifiedName, input)
+}
+
+func (m *Manager) CallOperationContext(ctx context.Context, qualifiedName string, input any) (*InvokeResponse, error) {
+    name, operation, err := m.resolveOperation(qualifiedName)
+    if err != nil {
+        return nil, err
+    }
+    return m.invoke(ctx, name, operation, input)
+}
+
+func (m *Manager) GetOperationStats(qualifiedName string) (*PluginStats, error) {
+    name, operation, err := m.resolveOperation(qualifiedName)
+    if err != nil {
+        return nil, err
+    }
+
+    m.statsMu.Lock()
+    defer m.statsMu.Unlock()
+
+    // Operations that never ran have zero stats, which are not recorded.
+    var snapshot PluginStats
+    if stats, ok := m.opStats[name][operation]; ok {
+        snapshot = *stats
+    }
+    return &snapshot, nil
+}
+
+// resolveOperation splits a "plugin.operation" name on its last dot and
+// checks that the plugin is loaded and advertises the operation. The plugin
+// may be given by an alias and qualified with a version; the returned name
+// is the key of the plugin version.
+func (m *Manager) resolveOperation(qualifiedName string) (string, string, error) {
+    i := strings.LastIndex(qualifiedName, ".")
+    if i <= 0 || i == len(qualifiedName)-1 {
+        return "", "", fmt.Errorf("%w: %s", ErrOperationNotFound, qualifiedName)
+    }
+    name, operation := qualifiedName[:i], qualifiedName[i+1:]
+
+    m.mu.RLock()
+    plugin, exists := m.lookupLocked(name)
+    m.mu.RUnlock()
+
+    if !exists {
+        return "", "", ErrPluginNotFound
+    }
+    if err := plugin.requireState("resolve operations", StateRunning, StateDisabled); err != nil {
+        return "", "", err
+    }
+
+    for _, op := range plugin.metadata.Operations {
+        if op.Name == operation {
+            return plugin.key, operation, nil
+        }
+    }
+    return "", "", fmt.Errorf("%w: %s", ErrOperationNotFound, qualifiedName)
+}
+
+// operationStats returns the stats for one operation of a plugin, creating
+// them on first use. The caller must hold m.statsMu.
+func (m *Manager) operationStats(name, operation string) *PluginStats {
+    ops, ok := m.opStats[name]
+    if !ok {
+        ops = make(map[string]*PluginStats)
+        m.opStats[name] = ops
+    }
+
+    stats, ok := ops[operation]
+    if !ok {
+        stats = &PluginStats{}
+        ops[operation] = stats
+    }
+    return stats
+}
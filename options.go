@@ -0,0 +1,130 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// Option configures a Manager created by NewManager.
+type Option func(*Manager)
+
+// Clock is the source of time used to measure plugin executions.
+type Clock interface {
+    Now() time.Time
+    Since(t time.Time) time.Duration
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// WithLogger replaces the default production zap logger.
+func WithLogger(logger *zap.Logger) Option {
+    return func(m *Manager) {
+        m.logger = logger
+    }
+}
+
+// WithSandbox replaces the default LinuxSandbox rooted in the plugin
+// directory.
+func WithSandbox(sandbox Sandbox) Option {
+    return func(m *Manager) {
+        m.sandbox = sandbox
+    }
+}
+
+// WithEventBus makes the manager publish its events on bus, for example to
+// share one bus between several managers. Panics in the bus's handlers are
+// logged unless the bus already has a PanicHandler.
+func WithEventBus(bus *EventBus) Option {
+    return func(m *Manager) {
+        m.eventBus = bus
+    }
+}
+
+// WithVerifier replaces the default RSA signature check that uses the public
+// key passed to NewManager.
+func WithVerifier(verifier Verifier) Option {
+    return func(m *Manager) {
+        m.verifier = verifier
+    }
+}
+
+// WithLoader adds a plugin backend. Loaders are tried in the order they are
+// given, before the built-in StaticLoader, GoPluginLoader, RPCLoader and
+// ExecLoader.
+func WithLoader(loader Loader) Option {
+    return func(m *Manager) {
+        m.loaders = append(m.loaders, loader)
+    }
+}
+
+// WithClock replaces the system clock used for execution stats.
+func WithClock(clock Clock) Option {
+    return func(m *Manager) {
+        m.clock = clock
+    }
+}
+
+// WithConfig uses config instead of loading one from the config path passed
+// to NewManager.
+func WithConfig(config *Config) Option {
+    return func(m *Manager) {
+        m.config = config
+    }
+}
+
+// WithDefaultTimeout is the option form of SetDefaultTimeout.
+func WithDefaultTimeout(timeout time.Duration) Option {
+    return func(m *Manager) {
+        m.defaultTimeout = timeout
+    }
+}
+
+// WithPanicThreshold is the option form of SetPanicThreshold.
+func WithPanicThreshold(n int) Option {
+    return func(m *Manager) {
+        m.panicThreshold = n
+    }
+}
+
+// WithLoadConcurrency is the option form of SetLoadConcurrency.
+func WithLoadConcurrency(n int) Option {
+    return func(m *Manager) {
+        m.loadConcurrency = n
+    }
+}
+
+// WithLoadPolicy sets the load policy of plugins that have none in the
+// config. The default is LoadEager.
+func WithLoadPolicy(policy LoadPolicy) Option {
+    return func(m *Manager) {
+        m.defaultPolicy = policy
+    }
+}
+
+// WithHostAPI declares the host API version the manager provides and the
+// range of versions it accepts plugins for, such as ">=1.0.0 <3.0.0".
+// Versions in the range other than the host's own are served through
+// adapters added with WithAPIAdapter. An empty range accepts ^version. The
+// default is HostAPIVersion.
+func WithHostAPI(version, supported string) Option {
+    return func(m *Manager) {
+        m.hostAPIVersion = version
+        m.hostAPIRange = supported
+    }
+}
+
+// WithAPIAdapter adds an adapter for plugins written against an older host
+// API version.
+func WithAPIAdapter(adapter APIAdapter) Option {
+    return func(m *Manager) {
+        m.apiAdapters = append(m.apiAdapters, adapter)
+    }
+}
@@ -5,16 +5,21 @@
 package pluginmanager
 
 import (
-    "plugin"
+    "context"
     "time"
 )
 
+// PluginMetadata describes a plugin. HostAPI is a constraint on the host
+// API versions the plugin was written against, such as "^1.0"; plugins
+// that leave it empty are taken to require "^1.0.0".
 type PluginMetadata struct {
     Name         string
     Version      string
     Dependencies map[string]string
     GoVersion    string
     Signature    []byte
+    Operations   []Operation
+    HostAPI      string
 }
 
 type Plugin interface {
@@ -27,6 +32,32 @@ type Plugin interface {
     Shutdown() error
 }
 
+// ContextInitializer is implemented by plugins whose initialization can
+// observe cancellation. The manager prefers InitContext over Init.
+type ContextInitializer interface {
+    InitContext(ctx context.Context) error
+}
+
+// ContextExecutor is implemented by plugins whose execution can observe
+// cancellation. The manager prefers ExecuteContext over Execute.
+type ContextExecutor interface {
+    ExecuteContext(ctx context.Context) error
+}
+
+// ContextShutdowner is implemented by plugins whose shutdown can observe
+// cancellation. The manager prefers ShutdownContext over Shutdown.
+type ContextShutdowner interface {
+    ShutdownContext(ctx context.Context) error
+}
+
+// StatefulPlugin is implemented by plugins that keep in-memory state across
+// hot-reloads. ExportState is called on the running version and ImportState
+// on its replacement, together with the version the state was exported from.
+type StatefulPlugin interface {
+    ExportState(ctx context.Context) ([]byte, error)
+    ImportState(ctx context.Context, fromVersion string, state []byte) error
+}
+
 type PluginStats struct {
     ExecutionCount     int64
     LastExecutionTime  time.Duration
@@ -35,21 +66,28 @@ type PluginStats struct {
 
 const PluginSymbol = "Plugin"
 
+// LoadPlugin opens a Go plugin without registering it with a Manager.
 func LoadPlugin(path string) (Plugin, error) {
-    p, err := plugin.Open(path)
-    if err != nil {
-        return nil, &PluginError{Op: "open", Err: err}
-    }
+    return GoPluginLoader{}.Load(path)
+}
 
-    symPlugin, err := p.Lookup(PluginSymbol)
-    if err != nil {
-        return nil, &PluginError{Op: "lookup", Err: err}
+func initPlugin(ctx context.Context, p Plugin) error {
+    if c, ok := p.(ContextInitializer); ok {
+        return c.InitContext(ctx)
     }
+    return p.Init()
+}
 
-    plugin, ok := symPlugin.(Plugin)
-    if !ok {
-        return nil, &PluginError{Op: "assert", Err: ErrInvalidPluginInterface}
+func executePlugin(ctx context.Context, p Plugin) error {
+    if c, ok := p.(ContextExecutor); ok {
+        return c.ExecuteContext(ctx)
     }
+    return p.Execute()
+}
 
-    return plugin, nil
+func shutdownPlugin(ctx context.Context, p Plugin) error {
+    if c, ok := p.(ContextShutdowner); ok {
+        return c.ShutdownContext(ctx)
+    }
+    return p.Shutdown()
 }
\ No newline at end of file
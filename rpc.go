@@ -0,0 +1,391 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/rpc"
+    "net/rpc/jsonrpc"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// RPCProtocolVersion is the version of the protocol spoken between the
+// manager and subprocess plugins. Both sides must use the same version.
+const RPCProtocolVersion = 1
+
+// rpcProtocolEnv is set in the environment of every subprocess plugin to the
+// protocol version the manager speaks.
+const rpcProtocolEnv = "PLUGIN_MANAGER_RPC_PROTOCOL"
+
+// defaultRPCRestarts is the MaxRestarts of the RPCLoader every Manager
+// starts with.
+const defaultRPCRestarts = 3
+
+// rpcHandshakeTimeout is how long a plugin process gets to write its
+// handshake before it is killed.
+const rpcHandshakeTimeout = 10 * time.Second
+
+// rpcCloseTimeout is how long a plugin process gets to exit after its stdin
+// is closed before it is killed.
+const rpcCloseTimeout = 5 * time.Second
+
+// rpcHandshake is the first line a subprocess plugin writes to stdout.
+type rpcHandshake struct {
+    Protocol int `json:"protocol"`
+}
+
+// rpcEmpty is the argument and reply of RPC methods that carry no data. It
+// aliases an unnamed type, as net/rpc only accepts exported or builtin types.
+type rpcEmpty = struct{}
+
+// Serve runs p as a subprocess plugin. It is called from the main function of
+// a plugin executable and speaks JSON-RPC with the manager over stdin and
+// stdout until the manager closes the connection. While Serve runs, os.Stdout
+// is redirected to stderr so that stray output cannot corrupt the protocol.
+func Serve(p Plugin) error {
+    version := os.Getenv(rpcProtocolEnv)
+    if version == "" {
+        return errors.New("pluginmanager: Serve must be started by a plugin manager")
+    }
+    if version != strconv.Itoa(RPCProtocolVersion) {
+        return fmt.Errorf("pluginmanager: manager speaks RPC protocol %s, plugin speaks %d", version, RPCProtocolVersion)
+    }
+
+    out := os.Stdout
+    os.Stdout = os.Stderr
+    defer func() { os.Stdout = out }()
+
+    if err := json.NewEncoder(out).Encode(rpcHandshake{Protocol: RPCProtocolVersion}); err != nil {
+        return fmt.Errorf("pluginmanager: failed to write handshake: %w", err)
+    }
+
+    server := rpc.NewServer()
+    if err := server.RegisterName("Plugin", &rpcServer{impl: p}); err != nil {
+        return err
+    }
+    server.ServeCodec(jsonrpc.NewServerCodec(rpcConn{Reader: os.Stdin, Writer: out, Closer: os.Stdin}))
+    return nil
+}
+
+// rpcServer exposes a Plugin to the manager on the plugin side.
+type rpcServer struct {
+    impl Plugin
+}
+
+func (s *rpcServer) Metadata(_ rpcEmpty, reply *PluginMetadata) error {
+    *reply = s.impl.Metadata()
+    return nil
+}
+
+func (s *rpcServer) PreLoad(_ rpcEmpty, _ *rpcEmpty) error {
+    return s.impl.PreLoad()
+}
+
+func (s *rpcServer) Init(_ rpcEmpty, _ *rpcEmpty) error {
+    return initPlugin(context.Background(), s.impl)
+}
+
+func (s *rpcServer) PostLoad(_ rpcEmpty, _ *rpcEmpty) error {
+    return s.impl.PostLoad()
+}
+
+func (s *rpcServer) Execute(_ rpcEmpty, _ *rpcEmpty) error {
+    return executePlugin(context.Background(), s.impl)
+}
+
+func (s *rpcServer) Invoke(req InvokeRequest, reply *InvokeResponse) error {
+    invoker, ok := s.impl.(Invoker)
+    if !ok {
+        return ErrInvokeNotSupported
+    }
+    resp, err := invoker.Invoke(context.Background(), req)
+    *reply = resp
+    return err
+}
+
+func (s *rpcServer) PreUnload(_ rpcEmpty, _ *rpcEmpty) error {
+    return s.impl.PreUnload()
+}
+
+func (s *rpcServer) Shutdown(_ rpcEmpty, _ *rpcEmpty) error {
+    return shutdownPlugin(context.Background(), s.impl)
+}
+
+// rpcConn joins the two halves of a stdio connection.
+type rpcConn struct {
+    io.Reader
+    io.Writer
+    io.Closer
+}
+
+// RPCLoader runs plugins as child processes that call Serve. It matches
+// executables ending in ".plugin". A plugin process that exits unexpectedly
+// is restarted on its next call, up to MaxRestarts times, and the lifecycle
+// hooks it had completed are replayed on the new process.
+type RPCLoader struct {
+    // MaxRestarts bounds how often a crashed plugin process is restarted.
+    MaxRestarts int
+    // Stderr receives the plugin processes' stderr. It defaults to the
+    // host's stderr.
+    Stderr io.Writer
+}
+
+func (l *RPCLoader) Match(path string) bool {
+    return filepath.Ext(path) == ".plugin"
+}
+
+func (l *RPCLoader) Load(path string) (Plugin, error) {
+    p := &rpcPlugin{path: path, name: filepath.Base(path), loader: l}
+    if err := p.start(); err != nil {
+        return nil, err
+    }
+
+    if err := p.proc.client.Call("Plugin.Metadata", rpcEmpty{}, &p.metadata); err != nil {
+        p.Close()
+        return nil, &PluginError{Op: "metadata", Plugin: p.name, Err: p.remoteError(err)}
+    }
+    return p, nil
+}
+
+// rpcPlugin is the manager side of a subprocess plugin. It implements the
+// Plugin interface, the context-aware variants and Invoker by forwarding to
+// the plugin process, and io.Closer to stop the process on unload.
+type rpcPlugin struct {
+    path     string
+    name     string
+    loader   *RPCLoader
+    metadata PluginMetadata
+
+    mu          sync.Mutex
+    proc        *rpcProcess
+    restarts    int
+    initialized bool
+    closed      bool
+}
+
+// rpcProcess is one run of a plugin executable.
+type rpcProcess struct {
+    cmd    *exec.Cmd
+    client *rpc.Client
+    exited chan struct{}
+}
+
+// stop waits up to timeout for the process to exit and kills it otherwise.
+func (proc *rpcProcess) stop(timeout time.Duration) {
+    select {
+    case <-proc.exited:
+    case <-time.After(timeout):
+        proc.cmd.Process.Kill()
+        <-proc.exited
+    }
+}
+
+// start launches the plugin process and checks its handshake. The caller
+// must hold p.mu or have exclusive access to p.
+func (p *rpcPlugin) start() error {
+    cmd := exec.Command(p.path)
+    cmd.Env = append(os.Environ(), rpcProtocolEnv+"="+strconv.Itoa(RPCProtocolVersion))
+    cmd.Stderr = p.loader.Stderr
+    if cmd.Stderr == nil {
+        cmd.Stderr = os.Stderr
+    }
+
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+    if err := cmd.Start(); err != nil {
+        return &PluginError{Op: "start", Plugin: p.name, Err: err}
+    }
+
+    proc := &rpcProcess{cmd: cmd, exited: make(chan struct{})}
+    go func() {
+        cmd.Wait()
+        close(proc.exited)
+    }()
+
+    reader := bufio.NewReader(stdout)
+    var handshake rpcHandshake
+    timer := time.AfterFunc(rpcHandshakeTimeout, func() { cmd.Process.Kill() })
+    line, err := reader.ReadBytes('\n')
+    timer.Stop()
+    if err == nil {
+        err = json.Unmarshal(line, &handshake)
+    }
+    if err == nil && handshake.Protocol != RPCProtocolVersion {
+        err = fmt.Errorf("%w: plugin speaks RPC protocol %d, host speaks %d", ErrIncompatibleVersion, handshake.Protocol, RPCProtocolVersion)
+    }
+    if err != nil {
+        proc.stop(0)
+        return &PluginError{Op: "handshake", Plugin: p.name, Err: err}
+    }
+
+    proc.client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(rpcConn{Reader: reader, Writer: stdin, Closer: stdin}))
+    p.proc = proc
+    return nil
+}
+
+// connection returns the current plugin process, restarting it if it has
+// exited.
+func (p *rpcPlugin) connection() (*rpcProcess, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.closed {
+        return nil, &PluginError{Op: "call", Plugin: p.name, Err: ErrPluginExited}
+    }
+
+    select {
+    case <-p.proc.exited:
+    default:
+        return p.proc, nil
+    }
+
+    if p.restarts >= p.loader.MaxRestarts {
+        return nil, &PluginError{Op: "restart", Plugin: p.name, Err: fmt.Errorf("%w after %d restarts", ErrPluginExited, p.restarts)}
+    }
+    p.restarts++
+    p.proc.client.Close()
+
+    if err := p.start(); err != nil {
+        return nil, err
+    }
+    if p.initialized {
+        for _, method := range []string{"PreLoad", "Init", "PostLoad"} {
+            if err := p.proc.client.Call("Plugin."+method, rpcEmpty{}, &rpcEmpty{}); err != nil {
+                return nil, &PluginError{Op: "restart", Plugin: p.name, Err: p.remoteError(err)}
+            }
+        }
+    }
+    return p.proc, nil
+}
+
+// call runs one RPC method on the plugin process. A cancelled ctx abandons
+// the call; the process keeps running.
+func (p *rpcPlugin) call(ctx context.Context, method string, args, reply any) error {
+    proc, err := p.connection()
+    if err != nil {
+        return err
+    }
+
+    call := proc.client.Go("Plugin."+method, args, reply, make(chan *rpc.Call, 1))
+    select {
+    case <-call.Done:
+        if call.Error == nil {
+            return nil
+        }
+        err := p.remoteError(call.Error)
+        if errors.Is(err, ErrPluginExited) {
+            // The connection is gone; make sure the process is too, so the
+            // next call restarts it.
+            proc.stop(rpcCloseTimeout)
+        }
+        return &PluginError{Op: method, Plugin: p.name, Err: err}
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// remoteError maps an RPC failure to the error the plugin reported, or to
+// ErrPluginExited when the connection was lost.
+func (p *rpcPlugin) remoteError(err error) error {
+    var serverErr rpc.ServerError
+    if errors.As(err, &serverErr) {
+        if string(serverErr) == ErrInvokeNotSupported.Error() {
+            return ErrInvokeNotSupported
+        }
+        return errors.New(string(serverErr))
+    }
+    if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, os.ErrClosed) {
+        return fmt.Errorf("%w: %v", ErrPluginExited, err)
+    }
+    return err
+}
+
+func (p *rpcPlugin) Metadata() PluginMetadata {
+    return p.metadata
+}
+
+func (p *rpcPlugin) PreLoad() error {
+    return p.call(context.Background(), "PreLoad", rpcEmpty{}, &rpcEmpty{})
+}
+
+func (p *rpcPlugin) Init() error {
+    return p.InitContext(context.Background())
+}
+
+func (p *rpcPlugin) InitContext(ctx context.Context) error {
+    return p.call(ctx, "Init", rpcEmpty{}, &rpcEmpty{})
+}
+
+func (p *rpcPlugin) PostLoad() error {
+    if err := p.call(context.Background(), "PostLoad", rpcEmpty{}, &rpcEmpty{}); err != nil {
+        return err
+    }
+
+    p.mu.Lock()
+    p.initialized = true
+    p.mu.Unlock()
+    return nil
+}
+
+func (p *rpcPlugin) Execute() error {
+    return p.ExecuteContext(context.Background())
+}
+
+func (p *rpcPlugin) ExecuteContext(ctx context.Context) error {
+    return p.call(ctx, "Execute", rpcEmpty{}, &rpcEmpty{})
+}
+
+func (p *rpcPlugin) Invoke(ctx context.Context, req InvokeRequest) (InvokeResponse, error) {
+    var resp InvokeResponse
+    err := p.call(ctx, "Invoke", req, &resp)
+    return resp, err
+}
+
+func (p *rpcPlugin) PreUnload() error {
+    return p.call(context.Background(), "PreUnload", rpcEmpty{}, &rpcEmpty{})
+}
+
+func (p *rpcPlugin) Shutdown() error {
+    return p.ShutdownContext(context.Background())
+}
+
+func (p *rpcPlugin) ShutdownContext(ctx context.Context) error {
+    p.mu.Lock()
+    p.initialized = false
+    p.mu.Unlock()
+
+    return p.call(ctx, "Shutdown", rpcEmpty{}, &rpcEmpty{})
+}
+
+// Close stops the plugin process. The process is asked to exit by closing
+// its stdin and is killed if it has not exited within rpcCloseTimeout.
+func (p *rpcPlugin) Close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.closed {
+        return nil
+    }
+    p.closed = true
+    p.proc.client.Close()
+    p.proc.stop(rpcCloseTimeout)
+    return nil
+}
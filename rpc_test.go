@@ -0,0 +1,213 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// rpcHelperEnv makes the test binary act as a subprocess plugin in the mode
+// it is set to, and rpcHelperLogEnv names the file that plugin records the
+// calls it receives in.
+const (
+    rpcHelperEnv    = "PLUGIN_MANAGER_RPC_HELPER"
+    rpcHelperLogEnv = "PLUGIN_MANAGER_RPC_HELPER_LOG"
+)
+
+// TestRPCHelperProcess is not a test but the subprocess plugin run by the
+// tests below, in the mode set by rpcHelperEnv:
+//
+//   - "serve" serves rpcHelperPlugin, which crashes when executed.
+//   - "mismatch" writes the handshake of another protocol version.
+func TestRPCHelperProcess(t *testing.T) {
+    switch os.Getenv(rpcHelperEnv) {
+    case "":
+        return
+    case "serve":
+        p := &rpcHelperPlugin{}
+        p.record("start")
+        if err := Serve(p); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+    case "mismatch":
+        json.NewEncoder(os.Stdout).Encode(rpcHandshake{Protocol: RPCProtocolVersion + 1})
+        io.Copy(io.Discard, os.Stdin)
+    }
+    os.Exit(0)
+}
+
+// rpcHelperPlugin records every call it receives in the file named by
+// rpcHelperLogEnv.
+type rpcHelperPlugin struct{}
+
+func (p *rpcHelperPlugin) record(call string) {
+    f, err := os.OpenFile(os.Getenv(rpcHelperLogEnv), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        panic(err)
+    }
+    defer f.Close()
+    fmt.Fprintln(f, call)
+}
+
+func (p *rpcHelperPlugin) Metadata() PluginMetadata {
+    return PluginMetadata{Name: "Remote", Version: "1.2.3", Dependencies: map[string]string{"Local": "^1.0.0"}}
+}
+
+func (p *rpcHelperPlugin) PreLoad() error   { p.record("preload"); return nil }
+func (p *rpcHelperPlugin) Init() error      { p.record("init"); return nil }
+func (p *rpcHelperPlugin) PostLoad() error  { p.record("postload"); return nil }
+func (p *rpcHelperPlugin) PreUnload() error { p.record("preunload"); return nil }
+func (p *rpcHelperPlugin) Shutdown() error  { p.record("shutdown"); return nil }
+
+func (p *rpcHelperPlugin) Execute() error {
+    p.record("execute")
+    os.Exit(3)
+    return nil
+}
+
+func (p *rpcHelperPlugin) Invoke(ctx context.Context, req InvokeRequest) (InvokeResponse, error) {
+    p.record("invoke")
+    if req.Operation == "fail" {
+        return InvokeResponse{}, errors.New("operation failed")
+    }
+    return InvokeResponse{Output: req.Input}, nil
+}
+
+// loadRPCHelper loads the test binary as a subprocess plugin in mode, through
+// a ".plugin" script that runs TestRPCHelperProcess, and returns the plugin
+// and the path of its call log.
+func loadRPCHelper(t *testing.T, loader *RPCLoader, mode string) (*rpcPlugin, string, error) {
+    t.Helper()
+    dir := t.TempDir()
+    script := filepath.Join(dir, "remote.plugin")
+    if err := os.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\nexec '%s' -test.run='^TestRPCHelperProcess$'\n", os.Args[0])), 0755); err != nil {
+        t.Fatal(err)
+    }
+    log := filepath.Join(dir, "calls.log")
+    t.Setenv(rpcHelperEnv, mode)
+    t.Setenv(rpcHelperLogEnv, log)
+    if loader.Stderr == nil {
+        loader.Stderr = io.Discard
+    }
+
+    plugin, err := loader.Load(script)
+    if err != nil {
+        return nil, log, err
+    }
+    p := plugin.(*rpcPlugin)
+    t.Cleanup(func() { p.Close() })
+    return p, log, nil
+}
+
+// rpcCalls returns the calls the helper plugin recorded in log.
+func rpcCalls(t *testing.T, log string) []string {
+    t.Helper()
+    data, err := os.ReadFile(log)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return strings.Fields(string(data))
+}
+
+func TestRPCPluginHandshake(t *testing.T) {
+    p, log, err := loadRPCHelper(t, &RPCLoader{}, "serve")
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    want := PluginMetadata{Name: "Remote", Version: "1.2.3", Dependencies: map[string]string{"Local": "^1.0.0"}}
+    if got := p.Metadata(); !reflect.DeepEqual(got, want) {
+        t.Fatalf("Metadata() = %+v, want %+v", got, want)
+    }
+
+    resp, err := p.Invoke(context.Background(), InvokeRequest{Operation: "echo", Input: json.RawMessage(`{"n":1}`)})
+    if err != nil || string(resp.Output) != `{"n":1}` {
+        t.Fatalf("Invoke returned %s, %v", resp.Output, err)
+    }
+    var pluginErr *PluginError
+    if _, err := p.Invoke(context.Background(), InvokeRequest{Operation: "fail"}); !errors.As(err, &pluginErr) || pluginErr.Err.Error() != "operation failed" {
+        t.Fatalf("failing Invoke returned %v, want a PluginError with the plugin's message", err)
+    }
+    if calls := rpcCalls(t, log); !reflect.DeepEqual(calls, []string{"start", "invoke", "invoke"}) {
+        t.Fatalf("plugin received %v", calls)
+    }
+}
+
+func TestRPCPluginProtocolMismatch(t *testing.T) {
+    _, _, err := loadRPCHelper(t, &RPCLoader{}, "mismatch")
+    var pluginErr *PluginError
+    if !errors.Is(err, ErrIncompatibleVersion) || !errors.As(err, &pluginErr) || pluginErr.Op != "handshake" {
+        t.Fatalf("Load returned %v, want a handshake error wrapping ErrIncompatibleVersion", err)
+    }
+
+    // Serve refuses to run for a manager of another protocol version, or
+    // without one.
+    t.Setenv(rpcProtocolEnv, "99")
+    if err := Serve(&rpcHelperPlugin{}); err == nil || !strings.Contains(err.Error(), "protocol 99") {
+        t.Fatalf("Serve for another protocol version returned %v", err)
+    }
+    t.Setenv(rpcProtocolEnv, "")
+    if err := Serve(&rpcHelperPlugin{}); err == nil {
+        t.Fatal("Serve without a manager succeeded")
+    }
+}
+
+func TestRPCPluginCrashRestartsAndReplaysHooks(t *testing.T) {
+    p, log, err := loadRPCHelper(t, &RPCLoader{MaxRestarts: 3}, "serve")
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    for _, hook := range []func() error{p.PreLoad, p.Init, p.PostLoad} {
+        if err := hook(); err != nil {
+            t.Fatalf("load hook: %v", err)
+        }
+    }
+
+    var pluginErr *PluginError
+    if err := p.Execute(); !errors.Is(err, ErrPluginExited) || !errors.As(err, &pluginErr) || pluginErr.Op != "Execute" {
+        t.Fatalf("Execute of a crashing plugin returned %v, want a PluginError wrapping ErrPluginExited", err)
+    }
+
+    // The next call restarts the process and replays the load hooks first.
+    if _, err := p.Invoke(context.Background(), InvokeRequest{Operation: "echo"}); err != nil {
+        t.Fatalf("Invoke after a crash: %v", err)
+    }
+    want := []string{
+        "start", "preload", "init", "postload", "execute",
+        "start", "preload", "init", "postload", "invoke",
+    }
+    if calls := rpcCalls(t, log); !reflect.DeepEqual(calls, want) {
+        t.Fatalf("plugin received %v, want %v", calls, want)
+    }
+}
+
+func TestRPCPluginRestartsAtMostMaxRestarts(t *testing.T) {
+    p, log, err := loadRPCHelper(t, &RPCLoader{MaxRestarts: 1}, "serve")
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+
+    for i := range 2 {
+        if err := p.Execute(); !errors.Is(err, ErrPluginExited) {
+            t.Fatalf("Execute %d returned %v, want ErrPluginExited", i, err)
+        }
+    }
+    var pluginErr *PluginError
+    if _, err := p.Invoke(context.Background(), InvokeRequest{Operation: "echo"}); !errors.Is(err, ErrPluginExited) || !errors.As(err, &pluginErr) || pluginErr.Op != "restart" {
+        t.Fatalf("Invoke after MaxRestarts returned %v, want a restart error wrapping ErrPluginExited", err)
+    }
+    if calls := rpcCalls(t, log); !reflect.DeepEqual(calls, []string{"start", "execute", "start", "execute"}) {
+        t.Fatalf("plugin received %v", calls)
+    }
+}
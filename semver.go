@@ -0,0 +1,187 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Version is a semantic version as specified by Semantic Versioning 2.0.0
+// (https://semver.org), such as "1.4.2", "2.0.0-rc.1" or "1.0.0+build.5".
+type Version struct {
+    Major      uint64
+    Minor      uint64
+    Patch      uint64
+    Prerelease []string
+    Build      []string
+}
+
+// ParseVersion parses a semantic version. The version must have all three
+// numeric parts, without leading zeros or a "v" prefix. The returned error
+// wraps ErrInvalidVersion.
+func ParseVersion(s string) (Version, error) {
+    var v Version
+    invalid := func(reason string) (Version, error) {
+        return Version{}, fmt.Errorf("%w %q: %s", ErrInvalidVersion, s, reason)
+    }
+
+    rest := s
+    if i := strings.IndexByte(rest, '+'); i >= 0 {
+        build, err := parseIdentifiers(rest[i+1:], false)
+        if err != nil {
+            return invalid("build metadata " + err.Error())
+        }
+        v.Build = build
+        rest = rest[:i]
+    }
+    if i := strings.IndexByte(rest, '-'); i >= 0 {
+        prerelease, err := parseIdentifiers(rest[i+1:], true)
+        if err != nil {
+            return invalid("prerelease " + err.Error())
+        }
+        v.Prerelease = prerelease
+        rest = rest[:i]
+    }
+
+    parts := strings.Split(rest, ".")
+    if len(parts) != 3 {
+        return invalid("must have the form MAJOR.MINOR.PATCH")
+    }
+    numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+    for i, part := range parts {
+        n, err := parseNumber(part)
+        if err != nil {
+            return invalid(err.Error())
+        }
+        *numbers[i] = n
+    }
+    return v, nil
+}
+
+// parseIdentifiers parses the dot-separated identifiers of a prerelease or
+// build metadata. Numeric prerelease identifiers must not have leading
+// zeros.
+func parseIdentifiers(s string, prerelease bool) ([]string, error) {
+    identifiers := strings.Split(s, ".")
+    for _, id := range identifiers {
+        if id == "" {
+            return nil, errors.New("has an empty identifier")
+        }
+        for _, r := range id {
+            if !isIdentifierRune(r) {
+                return nil, fmt.Errorf("identifier %q has invalid character %q", id, r)
+            }
+        }
+        if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+            return nil, fmt.Errorf("identifier %q has a leading zero", id)
+        }
+    }
+    return identifiers, nil
+}
+
+// parseNumber parses a numeric version part.
+func parseNumber(s string) (uint64, error) {
+    if s == "" || !isNumeric(s) {
+        return 0, fmt.Errorf("%q is not a number", s)
+    }
+    if len(s) > 1 && s[0] == '0' {
+        return 0, fmt.Errorf("%q has a leading zero", s)
+    }
+    n, err := strconv.ParseUint(s, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("%q is out of range", s)
+    }
+    return n, nil
+}
+
+func isIdentifierRune(r rune) bool {
+    return r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-'
+}
+
+func isNumeric(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] < '0' || s[i] > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// String returns the version in its canonical form.
+func (v Version) String() string {
+    s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+    if len(v.Prerelease) > 0 {
+        s += "-" + strings.Join(v.Prerelease, ".")
+    }
+    if len(v.Build) > 0 {
+        s += "+" + strings.Join(v.Build, ".")
+    }
+    return s
+}
+
+// Compare returns -1, 0 or +1 depending on whether v has lower, equal or
+// higher precedence than other. A prerelease has lower precedence than the
+// release it precedes, and build metadata is ignored.
+func (v Version) Compare(other Version) int {
+    if c := compareNumbers(v.Major, other.Major); c != 0 {
+        return c
+    }
+    if c := compareNumbers(v.Minor, other.Minor); c != 0 {
+        return c
+    }
+    if c := compareNumbers(v.Patch, other.Patch); c != 0 {
+        return c
+    }
+
+    switch {
+    case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+        return 0
+    case len(v.Prerelease) == 0:
+        return 1
+    case len(other.Prerelease) == 0:
+        return -1
+    }
+
+    for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+        if c := compareIdentifiers(v.Prerelease[i], other.Prerelease[i]); c != 0 {
+            return c
+        }
+    }
+    return compareNumbers(uint64(len(v.Prerelease)), uint64(len(other.Prerelease)))
+}
+
+// compareIdentifiers orders prerelease identifiers: numeric identifiers
+// numerically and before alphanumeric ones, which are ordered in ASCII
+// order.
+func compareIdentifiers(a, b string) int {
+    aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+    switch {
+    case aNumeric && bNumeric:
+        if c := compareNumbers(uint64(len(a)), uint64(len(b))); c != 0 {
+            return c
+        }
+        return strings.Compare(a, b)
+    case aNumeric:
+        return -1
+    case bNumeric:
+        return 1
+    default:
+        return strings.Compare(a, b)
+    }
+}
+
+func compareNumbers(a, b uint64) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    default:
+        return 0
+    }
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestParseVersion(t *testing.T) {
+    tests := []struct {
+        in   string
+        want Version
+    }{
+        {"0.0.0", Version{}},
+        {"1.4.2", Version{Major: 1, Minor: 4, Patch: 2}},
+        {"10.20.30", Version{Major: 10, Minor: 20, Patch: 30}},
+        {"1.0.0-alpha", Version{Major: 1, Prerelease: []string{"alpha"}}},
+        {"1.0.0-rc.1", Version{Major: 1, Prerelease: []string{"rc", "1"}}},
+        {"1.0.0-0.3.7", Version{Major: 1, Prerelease: []string{"0", "3", "7"}}},
+        {"1.0.0-x-y.0a", Version{Major: 1, Prerelease: []string{"x-y", "0a"}}},
+        {"1.0.0+build.5", Version{Major: 1, Build: []string{"build", "5"}}},
+        {"1.0.0+001", Version{Major: 1, Build: []string{"001"}}},
+        {"1.0.0-beta+exp.sha.5114f85", Version{Major: 1, Prerelease: []string{"beta"}, Build: []string{"exp", "sha", "5114f85"}}},
+        {"1.0.0+build-1", Version{Major: 1, Build: []string{"build-1"}}},
+    }
+    for _, tt := range tests {
+        got, err := ParseVersion(tt.in)
+        if err != nil {
+            t.Errorf("ParseVersion(%q): %v", tt.in, err)
+            continue
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("ParseVersion(%q) = %#v, want %#v", tt.in, got, tt.want)
+        }
+        if got.String() != tt.in {
+            t.Errorf("ParseVersion(%q).String() = %q", tt.in, got.String())
+        }
+    }
+}
+
+func TestParseVersionRejectsMalformed(t *testing.T) {
+    tests := []struct {
+        in     string
+        reason string
+    }{
+        {"", "empty"},
+        {"1", "missing minor and patch"},
+        {"1.2", "missing patch"},
+        {"1.2.3.4", "extra part"},
+        {"v1.2.3", "v prefix"},
+        {"V1.2.3", "capital v prefix"},
+        {" 1.2.3", "leading space"},
+        {"1.2.3 ", "trailing space"},
+        {"01.2.3", "leading zero in major"},
+        {"1.02.3", "leading zero in minor"},
+        {"1.2.03", "leading zero in patch"},
+        {"1.2.3-01", "leading zero in a numeric prerelease identifier"},
+        {"1.2.3-rc.01", "leading zero in a later prerelease identifier"},
+        {"1.-2.3", "negative minor"},
+        {"a.b.c", "non-numeric parts"},
+        {"1.2.x", "wildcard"},
+        {"1.2.3-", "empty prerelease"},
+        {"1.2.3-rc..1", "empty prerelease identifier"},
+        {"1.2.3-rc_1", "invalid prerelease character"},
+        {"1.2.3+", "empty build metadata"},
+        {"1.2.3+a..b", "empty build identifier"},
+        {"1.2.3+a+b", "second plus"},
+        {"1.2.3+ü", "non-ASCII build identifier"},
+        {"18446744073709551616.0.0", "major out of range"},
+    }
+    for _, tt := range tests {
+        if v, err := ParseVersion(tt.in); !errors.Is(err, ErrInvalidVersion) {
+            t.Errorf("ParseVersion(%q) with %s = %v, %v, want ErrInvalidVersion", tt.in, tt.reason, v, err)
+        }
+    }
+}
+
+func TestVersionPrecedence(t *testing.T) {
+    // Each version has higher precedence than the one before it, as in the
+    // example in section 11 of the specification.
+    ordered := []string{
+        "0.0.1",
+        "0.1.0",
+        "1.0.0-0",
+        "1.0.0-2",
+        "1.0.0-10",
+        "1.0.0-alpha",
+        "1.0.0-alpha.1",
+        "1.0.0-alpha.beta",
+        "1.0.0-beta",
+        "1.0.0-beta.2",
+        "1.0.0-beta.11",
+        "1.0.0-rc.1",
+        "1.0.0",
+        "1.0.1",
+        "1.1.0",
+        "2.0.0",
+        "10.0.0",
+    }
+    versions := make([]Version, len(ordered))
+    for i, s := range ordered {
+        v, err := ParseVersion(s)
+        if err != nil {
+            t.Fatalf("ParseVersion(%q): %v", s, err)
+        }
+        versions[i] = v
+    }
+    for i := range versions {
+        for j := range versions {
+            want := compareNumbers(uint64(i), uint64(j))
+            if got := versions[i].Compare(versions[j]); got != want {
+                t.Errorf("%s.Compare(%s) = %d, want %d", ordered[i], ordered[j], got, want)
+            }
+        }
+    }
+}
+
+func TestVersionCompareIgnoresBuildMetadata(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want int
+    }{
+        {"1.0.0+build.1", "1.0.0+build.2", 0},
+        {"1.0.0+build.1", "1.0.0", 0},
+        {"1.0.0-rc.1+a", "1.0.0-rc.1+b", 0},
+        {"1.0.0-rc.1+zzz", "1.0.0", -1},
+        {"1.0.1+aaa", "1.0.0+zzz", 1},
+    }
+    for _, tt := range tests {
+        a, err := ParseVersion(tt.a)
+        if err != nil {
+            t.Fatalf("ParseVersion(%q): %v", tt.a, err)
+        }
+        b, err := ParseVersion(tt.b)
+        if err != nil {
+            t.Fatalf("ParseVersion(%q): %v", tt.b, err)
+        }
+        if got := a.Compare(b); got != tt.want {
+            t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
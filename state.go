@@ -0,0 +1,177 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license 
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "fmt"
+
+    "go.uber.org/zap"
+)
+
+// PluginState is the position of a plugin in its lifecycle.
+type PluginState int
+
+const (
+    // StateDiscovered: the plugin's location is known. Lazy plugins stay
+    // here until their first use.
+    StateDiscovered PluginState = iota
+    // StateVerified: the plugin's signature has been checked.
+    StateVerified
+    // StateLoaded: the plugin has been opened and its metadata read.
+    StateLoaded
+    // StateInitialized: PreLoad and Init have succeeded.
+    StateInitialized
+    // StateRunning: PostLoad has succeeded and the plugin accepts executions.
+    StateRunning
+    // StateFailed: a lifecycle step failed or the plugin was quarantined.
+    StateFailed
+    // StateDisabled: the plugin is disabled in the config. A plugin that was
+    // disabled when it was loaded is not initialized until it is enabled.
+    StateDisabled
+    // StateUnloading: the plugin is draining and running its unload hooks.
+    StateUnloading
+    // StateUnloaded: the plugin has been removed from the manager.
+    StateUnloaded
+)
+
+var stateNames = map[PluginState]string{
+    StateDiscovered:  "discovered",
+    StateVerified:    "verified",
+    StateLoaded:      "loaded",
+    StateInitialized: "initialized",
+    StateRunning:     "running",
+    StateFailed:      "failed",
+    StateDisabled:    "disabled",
+    StateUnloading:   "unloading",
+    StateUnloaded:    "unloaded",
+}
+
+func (s PluginState) String() string {
+    if name, ok := stateNames[s]; ok {
+        return name
+    }
+    return fmt.Sprintf("PluginState(%d)", int(s))
+}
+
+// stateTransitions lists the states reachable from each state.
+var stateTransitions = map[PluginState][]PluginState{
+    StateDiscovered:  {StateVerified, StateFailed, StateUnloading},
+    StateVerified:    {StateLoaded, StateFailed, StateUnloading},
+    StateLoaded:      {StateInitialized, StateDisabled, StateFailed, StateUnloading},
+    StateInitialized: {StateRunning, StateFailed, StateUnloading},
+    StateRunning:     {StateDisabled, StateFailed, StateUnloading},
+    StateDisabled:    {StateInitialized, StateRunning, StateFailed, StateUnloading},
+    StateFailed:      {StateUnloading},
+    StateUnloading:   {StateUnloaded, StateFailed, StateRunning, StateDisabled},
+}
+
+func canTransition(from, to PluginState) bool {
+    for _, next := range stateTransitions[from] {
+        if next == to {
+            return true
+        }
+    }
+    return false
+}
+
+// StateError is returned when an operation is not allowed in a plugin's
+// current state. Cause is the error that put the plugin in that state, if
+// any, so errors.Is matches both ErrInvalidPluginState and the cause.
+type StateError struct {
+    Plugin string
+    State  PluginState
+    Op     string
+    Cause  error
+}
+
+func (e *StateError) Error() string {
+    msg := fmt.Sprintf("plugin %s cannot %s while %s", e.Plugin, e.Op, e.State)
+    if e.Cause != nil {
+        msg += fmt.Sprintf(" (%v)", e.Cause)
+    }
+    return msg
+}
+
+func (e *StateError) Unwrap() []error {
+    if e.Cause != nil {
+        return []error{ErrInvalidPluginState, e.Cause}
+    }
+    return []error{ErrInvalidPluginState}
+}
+
+// currentState returns the entry's state and the error that caused it.
+func (e *pluginEntry) currentState() (PluginState, error) {
+    e.stateMu.Lock()
+    defer e.stateMu.Unlock()
+    return e.state, e.cause
+}
+
+// isInitialized reports whether the entry's Init hook has succeeded.
+func (e *pluginEntry) isInitialized() bool {
+    e.stateMu.Lock()
+    defer e.stateMu.Unlock()
+    return e.initialized
+}
+
+// requireState returns a StateError unless the entry is in one of states.
+func (e *pluginEntry) requireState(op string, states ...PluginState) error {
+    e.stateMu.Lock()
+    key, state, cause := e.key, e.state, e.cause
+    e.stateMu.Unlock()
+
+    for _, s := range states {
+        if state == s {
+            return nil
+        }
+    }
+    return &StateError{Plugin: key, State: state, Op: op, Cause: cause}
+}
+
+// setState moves an entry to a new state and publishes a PluginStateChanged
+// event. Transitions not listed in stateTransitions are rejected.
+func (m *Manager) setState(entry *pluginEntry, to PluginState, cause error) error {
+    entry.stateMu.Lock()
+    from := entry.state
+    if !canTransition(from, to) {
+        err := &StateError{Plugin: entry.key, State: from, Op: "become " + to.String(), Cause: entry.cause}
+        entry.stateMu.Unlock()
+        return err
+    }
+    entry.state = to
+    entry.cause = cause
+    name, version := entry.name, entry.version
+    entry.stateMu.Unlock()
+
+    m.eventBus.Publish(PluginStateChangedEvent{
+        PluginName: name,
+        Version:    version,
+        Path:       entry.path,
+        OldState:   from,
+        NewState:   to,
+        Cause:      cause,
+    })
+    return nil
+}
+
+// failPlugin moves an entry to StateFailed and returns cause.
+func (m *Manager) failPlugin(entry *pluginEntry, cause error) error {
+    if err := m.setState(entry, StateFailed, cause); err != nil {
+        m.logger.Warn("Could not mark plugin as failed", zap.String("plugin", entry.key), zap.Error(err))
+    }
+    return cause
+}
+
+func (m *Manager) State(name string) (PluginState, error) {
+    m.mu.RLock()
+    entry, exists := m.lookupLocked(name)
+    m.mu.RUnlock()
+
+    if !exists {
+        return StateUnloaded, ErrPluginNotFound
+    }
+
+    state, _ := entry.currentState()
+    return state, nil
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "fmt"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// Several versions of a plugin can be loaded side by side. Each is
+// registered under its key, name@version, and can always be addressed by
+// it. The plugin name on its own refers to the default version: the one
+// pinned with SetDefaultVersion, or else the highest version that has not
+// failed. Dependencies resolve to the highest loaded version that satisfies
+// their constraint.
+
+// pluginKey returns the key a plugin version is registered under.
+func pluginKey(name, version string) string {
+    if version == "" {
+        return name
+    }
+    return name + "@" + version
+}
+
+// splitPluginKey splits a plugin reference into its name and, if it is
+// qualified as name@version, its version.
+func splitPluginKey(ref string) (string, string) {
+    i := strings.LastIndex(ref, "@")
+    if i <= 0 || i == len(ref)-1 {
+        return ref, ""
+    }
+    return ref[:i], ref[i+1:]
+}
+
+// SetDefaultVersion pins the version of a plugin that its unqualified name
+// refers to. The version must be loaded. An empty version removes the pin,
+// so the name refers to the highest version again.
+func (m *Manager) SetDefaultVersion(name, version string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    name = m.canonicalNameLocked(name)
+    if version == "" {
+        delete(m.defaults, name)
+        return nil
+    }
+    if _, exists := m.plugins[pluginKey(name, version)]; !exists {
+        return fmt.Errorf("%w: %s", ErrPluginNotFound, pluginKey(name, version))
+    }
+    m.defaults[name] = version
+    return nil
+}
+
+// PluginVersions returns the registered versions of a plugin, lowest first.
+func (m *Manager) PluginVersions(name string) []string {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    var versions []string
+    for _, entry := range m.versionsLocked(m.canonicalNameLocked(name)) {
+        versions = append(versions, entry.version)
+    }
+    return versions
+}
+
+// versionsLocked returns the registered entries of the plugin called name,
+// lowest version first. The slice must not be modified. The caller must hold
+// m.mu.
+func (m *Manager) versionsLocked(name string) []*pluginEntry {
+    return m.versions[name]
+}
+
+// putEntryLocked registers entry under its key and adds it to the versions
+// of its plugin. The caller must hold m.mu.
+func (m *Manager) putEntryLocked(entry *pluginEntry) {
+    m.plugins[entry.key] = entry
+
+    entry.semver = nil
+    if v, err := ParseVersion(entry.version); err == nil {
+        entry.semver = &v
+    }
+    // Version lists are shared with readers that got them from
+    // versionsLocked, so they are replaced rather than changed in place.
+    versions := m.versions[entry.name]
+    i := sort.Search(len(versions), func(i int) bool {
+        return compareEntryVersions(versions[i], entry) > 0
+    })
+    m.versions[entry.name] = slices.Insert(slices.Clone(versions), i, entry)
+}
+
+// deleteEntryLocked removes the entry registered under key from the registry
+// and from the versions of its plugin. The caller must hold m.mu.
+func (m *Manager) deleteEntryLocked(key string) {
+    entry, exists := m.plugins[key]
+    if !exists {
+        return
+    }
+    delete(m.plugins, key)
+
+    versions := m.versions[entry.name]
+    i := slices.Index(versions, entry)
+    switch {
+    case i < 0:
+    case len(versions) == 1:
+        delete(m.versions, entry.name)
+    default:
+        m.versions[entry.name] = slices.Delete(slices.Clone(versions), i, i+1)
+    }
+}
+
+// compareEntryVersions orders entries by the semantic version precedence of
+// their versions. Versions that are not valid, such as the empty version of
+// a plugin that has not been opened yet, order before every valid version
+// and among each other as strings.
+func compareEntryVersions(a, b *pluginEntry) int {
+    switch {
+    case a.semver != nil && b.semver != nil:
+        return a.semver.Compare(*b.semver)
+    case a.semver != nil:
+        return 1
+    case b.semver != nil:
+        return -1
+    default:
+        return strings.Compare(a.version, b.version)
+    }
+}
+
+// defaultVersionLocked returns the entry the unqualified name refers to. The
+// caller must hold m.mu.
+func (m *Manager) defaultVersionLocked(name string) (*pluginEntry, bool) {
+    entries := m.versionsLocked(name)
+    if len(entries) == 0 {
+        return nil, false
+    }
+    if pinned, ok := m.defaults[name]; ok {
+        if entry, exists := m.plugins[pluginKey(name, pinned)]; exists {
+            return entry, true
+        }
+    }
+    for i := len(entries) - 1; i >= 0; i-- {
+        if state, _ := entries[i].currentState(); state != StateFailed {
+            return entries[i], true
+        }
+    }
+    return entries[len(entries)-1], true
+}
+
+// isDefaultVersionLocked reports whether entry is the version its plugin's
+// name refers to. The caller must hold m.mu.
+func (m *Manager) isDefaultVersionLocked(entry *pluginEntry) bool {
+    current, _ := m.defaultVersionLocked(entry.name)
+    return current == entry
+}
+
+// dependencyCandidatesLocked returns the entries a dependency on ref may
+// resolve to, lowest version first: the version ref names, or else every
+// version of the plugin. The caller must hold m.mu.
+func (m *Manager) dependencyCandidatesLocked(ref string) []*pluginEntry {
+    name, version := splitPluginKey(ref)
+    if target, exists := m.aliases[name]; exists {
+        name = target
+    }
+    if version == "" {
+        return m.versionsLocked(name)
+    }
+    if entry, exists := m.plugins[pluginKey(name, version)]; exists {
+        return []*pluginEntry{entry}
+    }
+    return nil
+}
+
+// checkDependentsLocked checks that version of a plugin satisfies the
+// constraints of the plugins that depend on oldEntry, which it is to
+// replace in a hot-reload. The caller must hold m.mu.
+func (m *Manager) checkDependentsLocked(oldEntry *pluginEntry, version string) error {
+    for _, dependentKey := range m.dependentsOf(oldEntry.key) {
+        dependent, exists := m.plugins[dependentKey]
+        if !exists {
+            continue
+        }
+        for ref, constraint := range dependent.metadata.Dependencies {
+            name, refVersion := splitPluginKey(ref)
+            if target, exists := m.aliases[name]; exists {
+                name = target
+            }
+            if name != oldEntry.name {
+                continue
+            }
+            compatible, err := isVersionCompatible(version, constraint)
+            if err != nil || !compatible || (refVersion != "" && refVersion != version) {
+                return fmt.Errorf("%w: %s requires %s %s, new version is %s", ErrIncompatibleVersion, dependentKey, ref, constraint, version)
+            }
+        }
+    }
+    return nil
+}
+
+// replaceKeyLocked moves the dependents, stats and default pin of a plugin
+// version to the version replacing it in a hot-reload. The caller must hold
+// m.mu.
+func (m *Manager) replaceKeyLocked(oldEntry, newEntry *pluginEntry) {
+    // Dependency lists are shared with readers that copied them, so they
+    // are replaced rather than changed in place.
+    for plugin, deps := range m.dependencies {
+        if i := slices.Index(deps, oldEntry.key); i >= 0 {
+            deps = slices.Clone(deps)
+            deps[i] = newEntry.key
+            m.dependencies[plugin] = deps
+        }
+    }
+    if pinned, ok := m.defaults[oldEntry.name]; ok && pinned == oldEntry.version {
+        m.defaults[oldEntry.name] = newEntry.version
+    }
+
+    m.statsMu.Lock()
+    defer m.statsMu.Unlock()
+    if stats, ok := m.stats[oldEntry.key]; ok {
+        m.stats[newEntry.key] = stats
+        delete(m.stats, oldEntry.key)
+    }
+    if ops, ok := m.opStats[oldEntry.key]; ok {
+        m.opStats[newEntry.key] = ops
+        delete(m.opStats, oldEntry.key)
+    }
+}
@@ -0,0 +1,38 @@
+// Copyright (C) 2024 Matt Dunleavy. All rights reserved.
+// Use of this source code is subject to the MIT license
+// that can be found in the LICENSE file.
+
+package pluginmanager
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestHotReloadKeepsDependentConstraints(t *testing.T) {
+    m := newTestManager(t)
+    loadTestPlugin(t, m, &testPlugin{name: "RC"})
+    loadTestPlugin(t, m, &testPlugin{name: "RCD", deps: map[string]string{"RC": "^1.0.0"}})
+
+    incompatible := &testPlugin{name: "RC", version: "2.0.0"}
+    if err := m.HotReload("RC", registerTestPlugin(incompatible)); !errors.Is(err, ErrIncompatibleVersion) {
+        t.Fatalf("HotReload returned %v, want ErrIncompatibleVersion", err)
+    }
+    if dependents := m.Dependents("RC"); len(dependents) != 1 || dependents[0] != "RCD@1.0.0" {
+        t.Fatalf("Dependents after a refused reload are %v", dependents)
+    }
+    if versions := m.PluginVersions("RC"); len(versions) != 1 || versions[0] != "1.0.0" {
+        t.Fatalf("versions after a refused reload are %v, want [1.0.0]", versions)
+    }
+
+    compatible := &testPlugin{name: "RC", version: "1.1.0"}
+    if err := m.HotReload("RC", registerTestPlugin(compatible)); err != nil {
+        t.Fatalf("HotReload to a compatible version: %v", err)
+    }
+    if dependents := m.Dependents("RC"); len(dependents) != 1 || dependents[0] != "RCD@1.0.0" {
+        t.Fatalf("Dependents after the reload are %v", dependents)
+    }
+    if versions := m.PluginVersions("RC"); len(versions) != 1 || versions[0] != "1.1.0" {
+        t.Fatalf("versions after the reload are %v, want [1.1.0]", versions)
+    }
+}